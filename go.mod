@@ -3,13 +3,15 @@ module github.com/mattermost/mattermost-plugin-gmail
 go 1.12
 
 require (
-	github.com/DusanKasan/parsemail v1.2.0
 	github.com/JohannesKaufmann/html-to-markdown v0.0.0-20200719162213-853b8fb0f6f7
+	github.com/PuerkitoBio/goquery v1.5.1
 	github.com/dvsekhvalnov/jose2go v0.0.0-20180829124132-7f401d37b68a
 	github.com/mattermost/mattermost-server/v5 v5.24.0
 	github.com/mholt/archiver/v3 v3.3.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.5.1
+	golang.org/x/net v0.0.0-20200501053045-e0ff5e5a1de5
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	golang.org/x/text v0.3.2
 	google.golang.org/api v0.29.0
 )
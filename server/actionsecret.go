@@ -0,0 +1,14 @@
+package main
+
+import "crypto/hmac"
+
+// getActionSecret returns the secret passed in the context of the buttons of the plugin, to verify that actions come from them.
+// It is derived from the encryption key, as the key encrypts the tokens of the users and is not to be stored in the posts.
+func (p *Plugin) getActionSecret() string {
+	return deriveActionSecret(p.getConfiguration().EncryptionKey)
+}
+
+// isValidActionSecret checks the secret passed in the context of an action
+func (p *Plugin) isValidActionSecret(actionSecret string) bool {
+	return hmac.Equal([]byte(actionSecret), []byte(p.getActionSecret()))
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"golang.org/x/oauth2"
-	"google.golang.org/api/gmail/v1"
 	"net/http"
 	"strconv"
 	"strings"
@@ -28,6 +27,20 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 		p.completeGmailConnection(w, r)
 	case "/command/disconnect":
 		p.disconnectGmail(w, r)
+	case "/command/labels":
+		p.handleLabelAction(w, r)
+	case "/command/reply":
+		p.handleReplyAction(w, r)
+	case "/command/search":
+		p.handleSearchAction(w, r)
+	case "/command/attachment":
+		p.handleAttachmentAction(w, r)
+	case "/command/quote":
+		p.handleQuoteAction(w, r)
+	case "/dialog/send":
+		p.handleSendDialog(w, r)
+	case "/dialog/share":
+		p.handleShareDialog(w, r)
 	case "/webhook/gmail":
 		p.sendMailNotification(w, r)
 	default:
@@ -167,10 +180,10 @@ func (p *Plugin) disconnectGmail(w http.ResponseWriter, r *http.Request) {
 	actionToBeTaken := intergrationResponseFromCommand.Context["action"].(string)
 	channelID := intergrationResponseFromCommand.ChannelId
 	originalPostID := intergrationResponseFromCommand.PostId
-	actionSecret := p.getConfiguration().EncryptionKey
-	actionSecretPassed := intergrationResponseFromCommand.Context["actionSecret"].(string)
+	actionSecretPassed, _ := intergrationResponseFromCommand.Context["actionSecret"].(string)
+	validSecret := p.isValidActionSecret(actionSecretPassed)
 
-	if actionToBeTaken == ActionDisconnectPlugin && actionSecret == actionSecretPassed {
+	if actionToBeTaken == ActionDisconnectPlugin && validSecret {
 		err := p.offboardUser(userID)
 
 		if err != nil {
@@ -194,7 +207,7 @@ func (p *Plugin) disconnectGmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if actionToBeTaken == ActionCancel && actionSecret == actionSecretPassed {
+	if actionToBeTaken == ActionCancel && validSecret {
 		p.API.UpdateEphemeralPost(userID, &model.Post{
 			Id:        originalPostID,
 			UserId:    p.gmailBotID,
@@ -209,7 +222,84 @@ func (p *Plugin) disconnectGmail(w http.ResponseWriter, r *http.Request) {
 	p.API.DeleteEphemeralPost(userID, originalPostID)
 }
 
+func (p *Plugin) handleLabelAction(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Get the information from Body which contain the interactive Message Attachment we sent from /labels command
+	integrationRequest := model.PostActionIntegrationRequestFromJson(r.Body)
+	if integrationRequest == nil || integrationRequest.UserId != authUserID {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID := integrationRequest.UserId
+	channelID := integrationRequest.ChannelId
+	originalPostID := integrationRequest.PostId
+	actionToBeTaken, _ := integrationRequest.Context["action"].(string)
+	labelID, _ := integrationRequest.Context["labelID"].(string)
+	actionSecretPassed, _ := integrationRequest.Context["actionSecret"].(string)
+
+	if !p.isValidActionSecret(actionSecretPassed) || labelID == "" ||
+		(actionToBeTaken != ActionSubscribeLabel && actionToBeTaken != ActionUnsubscribeLabel) {
+		http.Error(w, "Unauthorized or unknown label action detected", http.StatusBadRequest)
+		return
+	}
+
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		p.sendMessageFromBot(channelID, userID, true, err.Error())
+		http.Error(w, "Could not get Gmail ID of the user", http.StatusInternalServerError)
+		return
+	}
+
+	subscriptions, err := p.getSubscriptionsOfUser(userID)
+	if err != nil {
+		p.sendMessageFromBot(channelID, userID, true, "Unable to fetch your subscriptions. Please try again later.")
+		http.Error(w, "Could not get subscriptions of the user", http.StatusInternalServerError)
+		return
+	}
+
+	updatedSubscriptions := []string{}
+	for _, subscription := range subscriptions {
+		if subscription != labelID {
+			updatedSubscriptions = append(updatedSubscriptions, subscription)
+		}
+	}
+	if actionToBeTaken == ActionSubscribeLabel {
+		updatedSubscriptions = append(updatedSubscriptions, labelID)
+	}
+
+	if err = p.subscribeToLabels(userID, gmailID, updatedSubscriptions); err != nil {
+		p.sendMessageFromBot(channelID, userID, true, "Unable to update your subscriptions. Please try again later.")
+		http.Error(w, "Could not update subscriptions of the user", http.StatusInternalServerError)
+		return
+	}
+
+	// Override the list with the updated subscriptions
+	labelsPost, err := p.getLabelsPost(userID, channelID)
+	if err != nil {
+		p.API.LogError("Could not fetch labels of the user", "err", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	labelsPost.Id = originalPostID
+	p.API.UpdateEphemeralPost(userID, labelsPost)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (p *Plugin) sendMailNotification(w http.ResponseWriter, r *http.Request) {
+	// Reject requests not pushed by the configured Pub/Sub subscription
+	if authErr := p.authenticateWebhook(r); authErr != nil {
+		p.API.LogWarn("Rejected unauthenticated Gmail notification", "err", authErr.Error())
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
 	// If the body isn't of type json, then reject
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
@@ -230,17 +320,42 @@ func (p *Plugin) sendMailNotification(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestMessage := parsedBody["message"].(map[string]interface{})
-	data := requestMessage["data"].(string)
-	decodedData, _ := p.decodeBase64URL(data)
+	// Reject malformed notifications rather than failing on them
+	requestMessage, ok := parsedBody["message"].(map[string]interface{})
+	if !ok {
+		http.Error(w, "No message found in the notification", http.StatusBadRequest)
+		return
+	}
+	data, ok := requestMessage["data"].(string)
+	if !ok {
+		http.Error(w, "No data found in the notification", http.StatusBadRequest)
+		return
+	}
+	decodedData, err := p.decodeBase64URL(data)
+	if err != nil {
+		http.Error(w, "Cannot decode the data of the notification", http.StatusBadRequest)
+		return
+	}
 
 	var parsedData map[string]interface{}
-	json.Unmarshal([]byte(decodedData), &parsedData)
-	emailAddress := parsedData["emailAddress"].(string)
-	historyID := uint64(parsedData["historyId"].(float64))
+	if err = json.Unmarshal([]byte(decodedData), &parsedData); err != nil {
+		http.Error(w, "Cannot unmarshal the data of the notification", http.StatusBadRequest)
+		return
+	}
+	emailAddress, ok := parsedData["emailAddress"].(string)
+	if !ok || emailAddress == "" {
+		http.Error(w, "No email address found in the notification", http.StatusBadRequest)
+		return
+	}
+	historyIDValue, ok := parsedData["historyId"].(float64)
+	if !ok {
+		http.Error(w, "No history ID found in the notification", http.StatusBadRequest)
+		return
+	}
+	historyID := uint64(historyIDValue)
 	userIDs, _ := p.getUsersForGmail(emailAddress)
 
-	p.API.LogInfo("Received Gmail notification for users connected to gmail ID: " + emailAddress)
+	p.API.LogInfo("Received Gmail notification with history ID: " + strconv.FormatUint(historyID, 10) + " for users connected to gmail ID: " + emailAddress)
 
 	if len(userIDs) < 1 {
 		p.API.LogInfo("No user connected to gmail ID: " + emailAddress)
@@ -266,43 +381,28 @@ func (p *Plugin) sendMailNotification(w http.ResponseWriter, r *http.Request) {
 		}
 
 		p.API.LogInfo("Fetching gmail messages using last used history ID: " + strconv.Itoa(int(lastHistoryID)))
-		historyResponse, histErr := gmailService.Users.History.List(emailAddress).StartHistoryId(lastHistoryID).Do()
+		messages, latestHistoryID, histErr := p.getMessagesAddedSince(gmailService, emailAddress, lastHistoryID)
 		if histErr != nil {
+			if isNotFound(histErr) {
+				// Gmail keeps the history for a limited time only. Without resetting the expired history ID,
+				// every following notification would fail the same way.
+				p.API.LogWarn("The last used history ID of the user has expired, skipping the mails received since it", "userID", userID, "historyID", strconv.FormatUint(lastHistoryID, 10))
+				if updateErr := p.updateHistoryIDForUser(historyID, userID); updateErr != nil {
+					p.API.LogError("Could not reset history ID for the user", "err", updateErr.Error())
+				}
+				continue
+			}
 			p.API.LogError("Could not fetch history response for user with user ID: "+userID, "err", histErr.Error())
 			continue
 		}
-		if len(historyResponse.History) < 1 {
-			p.API.LogInfo("Blank history response received for user with user ID: " + userID)
-			continue
-		}
-
-		lastHistoryIndex := len(historyResponse.History) - 1
-		addedMessages := historyResponse.History[lastHistoryIndex].MessagesAdded
-		messages := []*gmail.Message{}
 
-		for _, addedMessage := range addedMessages {
-			message, _ := gmailService.Users.Messages.Get(emailAddress, addedMessage.Message.Id).Format("raw").Do()
-			messages = append(messages, message)
-		}
 		p.API.LogInfo(fmt.Sprintf("%d messages received as a part of the notification, filtering based on user's subscriptions", len(messages)))
-		relevantMessages := p.getRelevantMessagesForUser(userID, messages)
-		if len(relevantMessages) < 1 {
-			p.API.LogInfo("No new relevant messages found for the user")
-			continue
-		}
-		p.API.LogInfo(fmt.Sprintf("%d messages relevant based on user's subscriptions", len(relevantMessages)))
-		directChannel, channelErr := p.API.GetDirectChannel(userID, p.gmailBotID)
-		if channelErr != nil {
-			p.API.LogError("Could not fetch direct channel for the user", "err", channelErr.Error())
-			continue
-		}
-		msgErr := p.handleMessages(relevantMessages, directChannel.Id, userID, true)
-		if msgErr != nil {
-			p.API.LogError("Message could not be posted to the user", "err", msgErr.Error())
+		if notifyErr := p.postNotifications(userID, messages); notifyErr != nil {
+			p.API.LogError("Message could not be posted to the user", "err", notifyErr.Error())
 			continue
 		}
 		p.API.LogInfo("Updating history ID for the user")
-		updateErr := p.updateHistoryIDForUser(historyID, userID)
+		updateErr := p.updateHistoryIDForUser(latestHistoryID, userID)
 		if updateErr != nil {
 			p.API.LogError("Could not update history ID for the user", "err", updateErr.Error())
 			continue
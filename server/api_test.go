@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSendMailNotificationPayload(t *testing.T) {
+	encode := func(data string) string {
+		return base64.URLEncoding.EncodeToString([]byte(data))
+	}
+
+	for name, test := range map[string]struct {
+		body           string
+		expectedStatus int
+	}{
+		"valid notification": {
+			body:           `{"message": {"data": "` + encode(`{"emailAddress": "someone@gmail.com", "historyId": 1234}`) + `"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		"invalid JSON": {
+			body:           `{"message": `,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"no message": {
+			body:           `{"subscription": "projects/project/subscriptions/gmail"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"message not an object": {
+			body:           `{"message": "hello"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"no data": {
+			body:           `{"message": {"messageId": "1"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"data not a string": {
+			body:           `{"message": {"data": 5}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"data not base64": {
+			body:           `{"message": {"data": "!!!!"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"data not JSON": {
+			body:           `{"message": {"data": "` + encode("hello") + `"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"no email address": {
+			body:           `{"message": {"data": "` + encode(`{"historyId": 1234}`) + `"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"history ID not a number": {
+			body:           `{"message": {"data": "` + encode(`{"emailAddress": "someone@gmail.com", "historyId": "1234"}`) + `"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("LogInfo", mock.Anything).Maybe()
+			api.On("KVGet", "someone@gmail.comusers").Return(nil, nil).Maybe()
+
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.setConfiguration(&configuration{WebhookAuthenticationType: webhookAuthTypeToken, WebhookSecret: "secret"})
+
+			request := httptest.NewRequest(http.MethodPost, "/webhook/gmail?token=secret", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			p.sendMailNotification(recorder, request)
+
+			assert.Equal(t, test.expectedStatus, recorder.Code)
+			api.AssertExpectations(t)
+		})
+	}
+}
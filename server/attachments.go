@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"google.golang.org/api/gmail/v1"
+)
+
+// skippedAttachment is an attachment of a mail not uploaded while posting the mail, which can be fetched on demand
+type skippedAttachment struct {
+	FileName string
+	// Index is the position of the attachment among the attachments of the mail with the same name
+	Index int
+	// PartID identifies the part of the attachment in the mail, as the files embedded in the mail may not have a name
+	PartID string
+	Size   int
+	Reason string
+}
+
+// uploadMailFile uploads the file of the mail to the channel under the given name, unless it is not to be uploaded
+// as per the configuration or the upload fails, in which case it is returned as skipped to be fetched on demand.
+// The files of the mail uploaded or skipped so far are counted by name in fileNameCounts.
+func (p *Plugin) uploadMailFile(file mailFile, fileName string, channelID string, fileNameCounts map[string]int) (*model.FileInfo, *skippedAttachment) {
+	skipped := &skippedAttachment{FileName: fileName, Index: fileNameCounts[fileName], PartID: file.PartID, Size: len(file.Data)}
+	fileNameCounts[fileName]++
+
+	if skipped.Reason = p.getConfiguration().getAttachmentSkipReason(fileName, len(file.Data)); skipped.Reason != "" {
+		return nil, skipped
+	}
+	fileInfo, appErr := p.API.UploadFile(file.Data, channelID, fileName)
+	if appErr != nil {
+		p.API.LogError("Attachment "+fileName+" could not be uploaded", "err", appErr.Error())
+		skipped.Reason = "The file could not be uploaded."
+		return nil, skipped
+	}
+	return fileInfo, nil
+}
+
+// getAttachmentSkipReason checks the attachment against the size limit and the allowed and blocked extensions,
+// returning why the attachment is not to be uploaded, or an empty string if it is to be uploaded
+func (c *configuration) getAttachmentSkipReason(fileName string, size int) string {
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+
+	if allowed := getExtensionList(c.AllowedAttachmentExtensions); len(allowed) > 0 && !allowed[extension] {
+		return "Files of this type are not uploaded automatically."
+	}
+	if getExtensionList(c.BlockedAttachmentExtensions)[extension] {
+		return "Files of this type are not uploaded automatically."
+	}
+
+	maxSize, err := strconv.ParseFloat(strings.TrimSpace(c.MaxAttachmentSize), 64)
+	if err == nil && maxSize > 0 && float64(size) > maxSize*1024*1024 {
+		return fmt.Sprintf("Files larger than %s MB are not uploaded automatically.", strings.TrimSpace(c.MaxAttachmentSize))
+	}
+	return ""
+}
+
+// getExtensionList parses the comma-separated list of file extensions, with or without the leading dot, ignoring case
+func getExtensionList(list string) map[string]bool {
+	extensions := map[string]bool{}
+	for _, extension := range strings.Split(list, ",") {
+		extension = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+		if extension != "" {
+			extensions[extension] = true
+		}
+	}
+	return extensions
+}
+
+// formatFileSize formats the size in bytes for display
+func formatFileSize(size int) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+// getSkippedAttachmentsPost creates the post listing the attachments of the mail not uploaded,
+// with a button to fetch each of them from the Gmail account of the user
+func (p *Plugin) getSkippedAttachmentsPost(userID string, messageID string, skippedAttachments []*skippedAttachment) *model.Post {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	actionURL := fmt.Sprintf("%s/plugins/%s/command/attachment", siteURL, manifest.Id)
+	actionSecret := p.getActionSecret()
+
+	attachments := []*model.SlackAttachment{}
+	for _, skipped := range skippedAttachments {
+		attachments = append(attachments, &model.SlackAttachment{
+			Title: skipped.FileName + " (" + formatFileSize(skipped.Size) + ")",
+			Text:  skipped.Reason,
+			Actions: []*model.PostAction{{
+				Type: model.POST_ACTION_TYPE_BUTTON,
+				Name: "Fetch attachment",
+				Integration: &model.PostActionIntegration{
+					URL: actionURL,
+					Context: map[string]interface{}{
+						"action":       ActionFetchAttachment,
+						"actionSecret": actionSecret,
+						"userID":       userID,
+						"messageID":    messageID,
+						"fileName":     skipped.FileName,
+						"index":        skipped.Index,
+						"partID":       skipped.PartID,
+					},
+				},
+			}},
+		})
+	}
+
+	return &model.Post{
+		Message: "The following attachments of the mail were not uploaded:",
+		Props: map[string]interface{}{
+			propFromGmailPlugin: true,
+			propGmailMessageID:  messageID,
+			"attachments":       attachments,
+		},
+	}
+}
+
+// handleAttachmentAction fetches the attachment not uploaded while posting the mail, and posts it in the thread of the mail
+func (p *Plugin) handleAttachmentAction(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	integrationRequest := model.PostActionIntegrationRequestFromJson(r.Body)
+	if integrationRequest == nil || integrationRequest.UserId != authUserID {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	channelID := integrationRequest.ChannelId
+	actionToBeTaken, _ := integrationRequest.Context["action"].(string)
+	actionSecretPassed, _ := integrationRequest.Context["actionSecret"].(string)
+
+	if !p.isValidActionSecret(actionSecretPassed) {
+		http.Error(w, "Unauthorized attachment action detected", http.StatusBadRequest)
+		return
+	}
+	if actionToBeTaken != ActionFetchAttachment {
+		http.Error(w, "Unknown attachment action detected", http.StatusBadRequest)
+		return
+	}
+
+	// The attachment is fetched from the Gmail account of the user who posted the mail
+	userID, _ := integrationRequest.Context["userID"].(string)
+	messageID, _ := integrationRequest.Context["messageID"].(string)
+	fileName, _ := integrationRequest.Context["fileName"].(string)
+	index, _ := integrationRequest.Context["index"].(float64)
+	// The buttons posted by earlier versions of the plugin identify the attachment by its name only
+	partID, hasPartID := integrationRequest.Context["partID"].(string)
+
+	post, appErr := p.API.GetPost(integrationRequest.PostId)
+	if appErr != nil {
+		http.Error(w, "Could not get the post", http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	var err error
+	if hasPartID {
+		data, err = p.fetchMailPart(userID, messageID, partID)
+	} else {
+		data, err = p.fetchAttachment(userID, messageID, fileName, int(index))
+	}
+	if err != nil {
+		p.API.LogError("Could not fetch the attachment", "err", err.Error())
+		p.sendMessageFromBot(channelID, authUserID, true, "Unable to fetch the attachment "+fileName+". Please try again later.")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	fileInfo, appErr := p.API.UploadFile(data, channelID, fileName)
+	if appErr != nil {
+		p.API.LogError("Attachment "+fileName+" could not be uploaded", "err", appErr.Error())
+		p.sendMessageFromBot(channelID, authUserID, true, "Unable to upload the attachment "+fileName+": "+appErr.Message)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+	if _, appErr = p.API.CreatePost(&model.Post{
+		UserId:    post.UserId,
+		ChannelId: channelID,
+		RootId:    rootID,
+		ParentId:  post.Id,
+		FileIds:   []string{fileInfo.Id},
+		Props:     map[string]interface{}{propFromGmailPlugin: true, propGmailMessageID: messageID},
+	}); appErr != nil {
+		p.API.LogError("Could not create post", "err", appErr.Error())
+		p.sendMessageFromBot(channelID, authUserID, true, "Unable to post the attachment "+fileName+".")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The attachment can no longer be fetched from the post
+	fetchedText := "Fetched."
+	if user, userErr := p.API.GetUser(authUserID); userErr == nil {
+		fetchedText = "Fetched by @" + user.Username + "."
+	}
+	attachments := post.Attachments()
+	for _, attachment := range attachments {
+		for _, action := range attachment.Actions {
+			if action.Integration != nil && action.Integration.Context["fileName"] == fileName && action.Integration.Context["index"] == index {
+				attachment.Actions = nil
+				attachment.Text = fetchedText
+				break
+			}
+		}
+	}
+	post.AddProp("attachments", attachments)
+	if _, appErr = p.API.UpdatePost(post); appErr != nil {
+		p.API.LogError("Could not update the post of the attachment", "err", appErr.Error())
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// fetchAttachment downloads the attachment with the given name (and position among the attachments with the name) of the Gmail message
+func (p *Plugin) fetchAttachment(userID string, messageID string, fileName string, index int) ([]byte, error) {
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := gmailService.Users.Messages.Get(gmailID, messageID).Format("full").Do()
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentParts := findAttachmentParts(message.Payload, fileName)
+	if index >= len(attachmentParts) {
+		return nil, fmt.Errorf("attachment %s not found in the message", fileName)
+	}
+
+	attachmentBody, err := gmailService.Users.Messages.Attachments.Get(gmailID, messageID, attachmentParts[index].Body.AttachmentId).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.decodeBase64URL(attachmentBody.Data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+// fetchMailPart downloads the file in the part of the Gmail message with the given ID, finding it in the raw message
+// as done while posting the message, or in the parts of the message in Gmail if the raw message cannot be parsed
+func (p *Plugin) fetchMailPart(userID string, messageID string, partID string) ([]byte, error) {
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := gmailService.Users.Messages.Get(gmailID, messageID).Format("raw").Do()
+	if err != nil {
+		return nil, err
+	}
+	rawMessage, err := p.decodeBase64URL(message.Raw)
+	if err == nil {
+		var parsed *parsedMessage
+		if parsed, err = parseMailMessage(rawMessage); err == nil {
+			for _, file := range append(parsed.Attachments, parsed.EmbeddedFiles...) {
+				if file.PartID == partID {
+					return file.Data, nil
+				}
+			}
+			return nil, fmt.Errorf("part %s not found in the message", partID)
+		}
+	}
+	p.API.LogWarn("Could not parse the raw message, using its parts in Gmail", "messageID", messageID, "err", err.Error())
+
+	message, err = gmailService.Users.Messages.Get(gmailID, messageID).Format("full").Do()
+	if err != nil {
+		return nil, err
+	}
+	part := findPayloadPart(message.Payload, partID)
+	if part == nil || part.Body == nil {
+		return nil, fmt.Errorf("part %s not found in the message", partID)
+	}
+
+	data := part.Body.Data
+	if part.Body.AttachmentId != "" {
+		attachmentBody, err := gmailService.Users.Messages.Attachments.Get(gmailID, messageID, part.Body.AttachmentId).Do()
+		if err != nil {
+			return nil, err
+		}
+		data = attachmentBody.Data
+	}
+	decoded, err := p.decodeBase64URL(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decoded), nil
+}
+
+// findPayloadPart returns the part of the Gmail message with the given ID, or nil if not found
+func findPayloadPart(part *gmail.MessagePart, partID string) *gmail.MessagePart {
+	if part == nil || part.PartId == partID {
+		return part
+	}
+	for _, childPart := range part.Parts {
+		if found := findPayloadPart(childPart, partID); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findAttachmentParts returns the parts of the message, in order, that are attachments with the given name
+func findAttachmentParts(part *gmail.MessagePart, fileName string) []*gmail.MessagePart {
+	if part == nil {
+		return nil
+	}
+
+	attachmentParts := []*gmail.MessagePart{}
+	if part.Filename == fileName && part.Body != nil && part.Body.AttachmentId != "" {
+		attachmentParts = append(attachmentParts, part)
+	}
+	for _, childPart := range part.Parts {
+		attachmentParts = append(attachmentParts, findAttachmentParts(childPart, fileName)...)
+	}
+	return attachmentParts
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestGetAttachmentSkipReason(t *testing.T) {
+	for name, test := range map[string]struct {
+		config   configuration
+		fileName string
+		size     int
+		expected string
+	}{
+		"no limits": {
+			fileName: "report.pdf",
+			size:     50 * 1024 * 1024,
+		},
+		"allowed extension": {
+			config:   configuration{AllowedAttachmentExtensions: "pdf, .PNG"},
+			fileName: "inline-1.png",
+		},
+		"extension not allowed": {
+			config:   configuration{AllowedAttachmentExtensions: "pdf"},
+			fileName: "inline-2.eml",
+			expected: "Files of this type are not uploaded automatically.",
+		},
+		"blocked extension": {
+			config:   configuration{BlockedAttachmentExtensions: "exe,bin"},
+			fileName: "inline-3.bin",
+			expected: "Files of this type are not uploaded automatically.",
+		},
+		"file without extension": {
+			config:   configuration{AllowedAttachmentExtensions: "pdf"},
+			fileName: "inline-4",
+			expected: "Files of this type are not uploaded automatically.",
+		},
+		"within the size limit": {
+			config:   configuration{MaxAttachmentSize: "1"},
+			fileName: "photo.jpg",
+			size:     1024 * 1024,
+		},
+		"larger than the size limit": {
+			config:   configuration{MaxAttachmentSize: "1"},
+			fileName: "photo.jpg",
+			size:     1024*1024 + 1,
+			expected: "Files larger than 1 MB are not uploaded automatically.",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.config.getAttachmentSkipReason(test.fileName, test.size))
+		})
+	}
+}
+
+func TestFindPayloadPart(t *testing.T) {
+	payload := &gmail.MessagePart{
+		PartId:   "",
+		MimeType: "multipart/mixed",
+		Parts: []*gmail.MessagePart{
+			{
+				PartId:   "0",
+				MimeType: "multipart/related",
+				Parts: []*gmail.MessagePart{
+					{PartId: "0.0", MimeType: "text/html"},
+					{PartId: "0.1", MimeType: "image/png"},
+				},
+			},
+			{PartId: "1", MimeType: "application/octet-stream"},
+		},
+	}
+
+	for name, test := range map[string]struct {
+		partID   string
+		expected string
+	}{
+		"mail":         {partID: "", expected: "multipart/mixed"},
+		"nested part":  {partID: "0.1", expected: "image/png"},
+		"last part":    {partID: "1", expected: "application/octet-stream"},
+		"missing part": {partID: "0.2"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			part := findPayloadPart(payload, test.partID)
+			if test.expected == "" {
+				assert.Nil(t, part)
+				return
+			}
+			if assert.NotNil(t, part) {
+				assert.Equal(t, test.expected, part.MimeType)
+			}
+		})
+	}
+}
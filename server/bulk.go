@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/gmail/v1"
+)
+
+// bulkImportJobKeyPrefix prefixes the KV store keys holding the state of the bulk import jobs
+const bulkImportJobKeyPrefix = "bulkImportJob_"
+
+// bulkImportLockKeyPrefix prefixes the KV store keys used to make sure a single server of the cluster runs a bulk import job
+const bulkImportLockKeyPrefix = "bulkImportLock_"
+
+// bulkImportLockExpiry is the time after which the lock of a bulk import job expires if not refreshed
+const bulkImportLockExpiry = 5 * time.Minute
+
+// bulkImportRetryInterval is the time after which a job is tried again if its lock is held,
+// as the lock may be left by a server that stopped while running the job
+const bulkImportRetryInterval = bulkImportLockExpiry
+
+// bulkImportDefaultLimit is the number of mails imported by a bulk import if no limit is provided
+const bulkImportDefaultLimit = 100
+
+// bulkImportMaxLimit is the maximum number of mails that can be imported by a bulk import
+const bulkImportMaxLimit = 1000
+
+// bulkImportProgressInterval is the number of imported mails after which the progress of a bulk import is updated
+const bulkImportProgressInterval = 10
+
+// limitFlag is used to limit the number of mails imported by a bulk import
+const limitFlag = "--limit"
+
+// threadsFlag is used to import the complete threads of the matching mails in a bulk import
+const threadsFlag = "--threads"
+
+// bulkImportJob is the state of an import of the mails matching a query into a channel, stored to resume it after a restart
+type bulkImportJob struct {
+	ID        string
+	UserID    string
+	ChannelID string
+	Query     string
+	Limit     int
+	// Threads is set if the complete threads of the matching mails are imported
+	Threads bool
+	// Force is set if the mails imported in the channel before are imported again
+	Force bool
+	// KeepQuotes is set if the quoted reply history of the mails is kept in their posts
+	KeepQuotes bool
+	// IDs are the IDs of the matching mails, or of their threads, in chronological order. Nil until listed.
+	IDs []string
+	// Imported is the number of IDs processed so far, of which Failed could not be imported
+	Imported int
+	Failed   int
+	// ProgressPostID is the ID of the direct message from the bot showing the progress of the job
+	ProgressPostID string
+}
+
+// handleImportQueryCommand handles the command `/gmail import query "<gmail query>" [--limit N] [--threads] [--force] [--keep-quotes]`
+// by importing the matching mails in the background
+func (p *Plugin) handleImportQueryCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	arguments := strings.TrimSpace(strings.TrimPrefix(args.Command, "/"+commandGmail+" import query"))
+	job, err := parseImportQueryArgs(arguments)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+	job.ID = model.NewId()
+	job.UserID = args.UserId
+	job.ChannelID = args.ChannelId
+
+	progressPostID, err := p.CreateBotDMPost(args.UserId, job.getProgressMessage(p.getChannelDisplayName(args.ChannelId)))
+	if err != nil {
+		p.API.LogError("Could not post the progress of the import", "err", err.Error())
+	}
+	job.ProgressPostID = progressPostID
+
+	if err = p.storeBulkImportJob(job); err != nil {
+		p.API.LogError("Could not store the import job", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to start the import. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+	p.startBulkImportJob(job)
+
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "The mails matching `"+job.Query+"` are being imported in the background. The Gmail Bot will keep you updated on the progress.")
+	return &model.CommandResponse{}, nil
+}
+
+// parseImportQueryArgs parses the arguments of the bulk import command, in which the query can be enclosed in double quotes,
+// into the job importing the mails
+func parseImportQueryArgs(arguments string) (*bulkImportJob, error) {
+	usageErr := errors.New("Please provide the query of the mails to import, eg. `/gmail import query \"from:someone@example.com\" --limit 50`.")
+
+	query := ""
+	if strings.HasPrefix(arguments, "\"") {
+		end := strings.Index(arguments[1:], "\"")
+		if end == -1 {
+			return nil, usageErr
+		}
+		query = arguments[1 : end+1]
+		arguments = arguments[end+2:]
+	}
+
+	job := &bulkImportJob{Limit: bulkImportDefaultLimit}
+	queryFields := []string{}
+	fields := strings.Fields(arguments)
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case limitFlag:
+			if i+1 == len(fields) {
+				return nil, errors.New("Please provide the number of mails to import after `" + limitFlag + "`.")
+			}
+			parsedLimit, err := strconv.Atoi(fields[i+1])
+			if err != nil || parsedLimit <= 0 || parsedLimit > bulkImportMaxLimit {
+				return nil, errors.Errorf("The limit should be a number from 1 to %d.", bulkImportMaxLimit)
+			}
+			job.Limit = parsedLimit
+			i++
+		case threadsFlag:
+			job.Threads = true
+		case forceFlag:
+			job.Force = true
+		case keepQuotesFlag:
+			job.KeepQuotes = true
+		default:
+			queryFields = append(queryFields, fields[i])
+		}
+	}
+
+	if query == "" {
+		query = strings.Join(queryFields, " ")
+	} else if len(queryFields) > 0 {
+		return nil, usageErr
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, usageErr
+	}
+	job.Query = strings.TrimSpace(query)
+	return job, nil
+}
+
+// getProgressMessage describes the progress of the job
+func (job *bulkImportJob) getProgressMessage(channelName string) string {
+	what := "mails"
+	if job.Threads {
+		what = "threads of the mails"
+	}
+	header := fmt.Sprintf("###### Import of the %s matching `%s` in %s\n", what, job.Query, channelName)
+
+	if job.IDs == nil {
+		return header + "Searching for the mails..."
+	}
+	if len(job.IDs) == 0 {
+		return header + "No mails match the query."
+	}
+
+	progress := fmt.Sprintf("Imported %d of %d.", job.Imported-job.Failed, len(job.IDs))
+	if job.Failed > 0 {
+		progress += fmt.Sprintf(" %d could not be imported.", job.Failed)
+	}
+	if job.Imported == len(job.IDs) {
+		progress = ":white_check_mark: Completed. " + progress
+	}
+	return header + progress
+}
+
+// getChannelDisplayName returns the name of the channel to refer to it in messages
+func (p *Plugin) getChannelDisplayName(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return "the channel"
+	}
+	if channel.Type == model.CHANNEL_DIRECT || channel.Type == model.CHANNEL_GROUP {
+		return "the direct message"
+	}
+	return "~" + channel.Name
+}
+
+// storeBulkImportJob stores the state of the job
+func (p *Plugin) storeBulkImportJob(job *bulkImportJob) error {
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(bulkImportJobKeyPrefix+job.ID, jobJSON); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getBulkImportJob returns the stored state of the job, nil if the job was completed or cancelled
+func (p *Plugin) getBulkImportJob(jobID string) (*bulkImportJob, error) {
+	jobJSON, appErr := p.API.KVGet(bulkImportJobKeyPrefix + jobID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if jobJSON == nil {
+		return nil, nil
+	}
+
+	var job bulkImportJob
+	if err := json.Unmarshal(jobJSON, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// resumeBulkImportJobs resumes the bulk import jobs interrupted by the deactivation of the plugin
+func (p *Plugin) resumeBulkImportJobs() {
+	p.bulkImportStopLock.Lock()
+	p.bulkImportStop = make(chan bool)
+	p.bulkImportStopLock.Unlock()
+
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			p.API.LogError("Could not list the import jobs to resume", "err", appErr.Error())
+			return
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, bulkImportJobKeyPrefix) {
+				continue
+			}
+			job, err := p.getBulkImportJob(strings.TrimPrefix(key, bulkImportJobKeyPrefix))
+			if err != nil {
+				p.API.LogError("Could not fetch the import job to resume", "err", err.Error())
+				continue
+			}
+			if job != nil {
+				p.startBulkImportJob(job)
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return
+		}
+	}
+}
+
+// stopBulkImportJobs stops the running bulk import jobs, without removing their state, and waits for them to stop
+func (p *Plugin) stopBulkImportJobs() {
+	p.bulkImportStopLock.Lock()
+	stop := p.bulkImportStop
+	p.bulkImportStop = nil
+	p.bulkImportStopLock.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	p.bulkImportJobs.Wait()
+}
+
+// startBulkImportJob runs the job in the background. If the job is run by another server, or the lock was left by a server
+// that stopped, it is tried again after bulkImportRetryInterval until it is completed or cancelled.
+// Jobs started while the plugin is deactivated are resumed on its activation.
+func (p *Plugin) startBulkImportJob(job *bulkImportJob) {
+	p.bulkImportStopLock.Lock()
+	stop := p.bulkImportStop
+	if stop == nil {
+		p.bulkImportStopLock.Unlock()
+		return
+	}
+	p.bulkImportJobs.Add(1)
+	p.bulkImportStopLock.Unlock()
+
+	go func() {
+		defer p.bulkImportJobs.Done()
+
+		retry := p.runBulkImportJob(job, stop)
+		for retry {
+			select {
+			case <-stop:
+				return
+			case <-time.After(bulkImportRetryInterval):
+			}
+
+			latestJob, err := p.getBulkImportJob(job.ID)
+			if err != nil {
+				p.API.LogError("Could not fetch the import job to retry", "err", err.Error())
+				continue
+			}
+			if latestJob == nil {
+				return
+			}
+			job = latestJob
+			retry = p.runBulkImportJob(job, stop)
+		}
+	}()
+}
+
+// runBulkImportJob imports the mails of the job not imported yet, until all are imported or stop is closed.
+// The lock makes sure that the job is not run by another server of the cluster at the same time.
+// It returns whether the lock could not be acquired, in which case the job is to be tried again later.
+func (p *Plugin) runBulkImportJob(job *bulkImportJob, stop chan bool) bool {
+	lockKey := bulkImportLockKeyPrefix + job.ID
+	lockOptions := model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: int64(bulkImportLockExpiry / time.Second),
+	}
+	acquired, appErr := p.API.KVSetWithOptions(lockKey, []byte(model.NewId()), lockOptions)
+	if appErr != nil {
+		p.API.LogError("Could not acquire lock for the import job", "err", appErr.Error())
+		return true
+	}
+	if !acquired {
+		return true
+	}
+	defer p.API.KVDelete(lockKey)
+
+	gmailID, err := p.getGmailID(job.UserID)
+	if err != nil {
+		p.API.LogError("Could not get Gmail ID of the user, cancelling the import", "err", err.Error())
+		p.API.KVDelete(bulkImportJobKeyPrefix + job.ID)
+		p.CreateBotDMPost(job.UserID, "The import of the mails matching `"+job.Query+"` was cancelled as your Gmail account is no longer connected.")
+		return false
+	}
+
+	channelName := p.getChannelDisplayName(job.ChannelID)
+	if job.IDs == nil {
+		ids, listErr := p.listBulkImportIDs(job, gmailID)
+		if listErr != nil {
+			p.API.LogError("Could not list the mails to import, cancelling the import", "err", listErr.Error())
+			p.API.KVDelete(bulkImportJobKeyPrefix + job.ID)
+			p.updateBulkImportProgress(job, "Unable to search for the mails matching `"+job.Query+"`. Please check the query and try again.")
+			return false
+		}
+		job.IDs = ids
+		if err = p.storeBulkImportJob(job); err != nil {
+			p.API.LogError("Could not store the import job", "err", err.Error())
+		}
+		p.updateBulkImportProgress(job, job.getProgressMessage(channelName))
+	}
+
+	for job.Imported < len(job.IDs) {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+
+		if importErr := p.importBulkImportItem(job, gmailID, job.IDs[job.Imported]); importErr != nil {
+			p.API.LogError("Could not import the mail "+job.IDs[job.Imported], "err", importErr.Error())
+			job.Failed++
+		}
+		job.Imported++
+
+		if err = p.storeBulkImportJob(job); err != nil {
+			p.API.LogError("Could not store the progress of the import job", "err", err.Error())
+		}
+		p.API.KVSetWithOptions(lockKey, []byte(model.NewId()), model.PluginKVSetOptions{ExpireInSeconds: lockOptions.ExpireInSeconds})
+
+		if job.Imported%bulkImportProgressInterval == 0 || job.Imported == len(job.IDs) {
+			p.updateBulkImportProgress(job, job.getProgressMessage(channelName))
+		}
+	}
+
+	p.API.KVDelete(bulkImportJobKeyPrefix + job.ID)
+	return false
+}
+
+// listBulkImportIDs lists the IDs of the mails matching the query of the job, or of their threads, in chronological order
+func (p *Plugin) listBulkImportIDs(job *bulkImportJob, gmailID string) ([]string, error) {
+	gmailService, err := p.getGmailService(job.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	addedIDs := map[string]bool{}
+	pageToken := ""
+	for len(ids) < job.Limit {
+		listResponse, listErr := gmailService.Users.Messages.List(gmailID).Q(job.Query).PageToken(pageToken).Do()
+		if listErr != nil {
+			return nil, listErr
+		}
+
+		for _, message := range listResponse.Messages {
+			id := message.Id
+			if job.Threads {
+				id = message.ThreadId
+			}
+			if addedIDs[id] {
+				continue
+			}
+			addedIDs[id] = true
+			ids = append(ids, id)
+			if len(ids) == job.Limit {
+				break
+			}
+		}
+
+		if listResponse.NextPageToken == "" {
+			break
+		}
+		pageToken = listResponse.NextPageToken
+	}
+
+	// The latest mails are listed first
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids, nil
+}
+
+// importBulkImportItem imports the mail, or the thread, with the ID in the channel of the job.
+// Mails imported in the channel before are skipped unless the job is forced.
+func (p *Plugin) importBulkImportItem(job *bulkImportJob, gmailID string, id string) error {
+	if job.Threads {
+		threadMessages, err := p.getRawThreadMessages(job.UserID, gmailID, id)
+		if err != nil {
+			return err
+		}
+		_, err = p.handleMessages(threadMessages, job.ChannelID, job.UserID, false, importOptions{Force: job.Force, KeepQuotes: job.KeepQuotes})
+		return err
+	}
+
+	message, err := p.getRawMessage(job.UserID, gmailID, id)
+	if err != nil {
+		return err
+	}
+	_, err = p.handleMessages([]*gmail.Message{message}, job.ChannelID, job.UserID, false, importOptions{Force: job.Force, KeepQuotes: job.KeepQuotes})
+	return err
+}
+
+// updateBulkImportProgress updates the direct message showing the progress of the job
+func (p *Plugin) updateBulkImportProgress(job *bulkImportJob, message string) {
+	if job.ProgressPostID == "" {
+		return
+	}
+	post, appErr := p.API.GetPost(job.ProgressPostID)
+	if appErr != nil {
+		p.API.LogError("Could not fetch the progress post of the import job", "err", appErr.Error())
+		return
+	}
+	post.Message = message
+	if _, appErr = p.API.UpdatePost(post); appErr != nil {
+		p.API.LogError("Could not update the progress post of the import job", "err", appErr.Error())
+	}
+}
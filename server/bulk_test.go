@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportQueryArgs(t *testing.T) {
+	for name, test := range map[string]struct {
+		arguments   string
+		expected    *bulkImportJob
+		expectedErr string
+	}{
+		"unquoted query": {
+			arguments: "from:someone@example.com is:unread",
+			expected:  &bulkImportJob{Query: "from:someone@example.com is:unread", Limit: bulkImportDefaultLimit},
+		},
+		"quoted query": {
+			arguments: `"subject:(weekly report) -label:archive"`,
+			expected:  &bulkImportJob{Query: "subject:(weekly report) -label:archive", Limit: bulkImportDefaultLimit},
+		},
+		"quoted query containing flags": {
+			arguments: `"--limit 5 --force"`,
+			expected:  &bulkImportJob{Query: "--limit 5 --force", Limit: bulkImportDefaultLimit},
+		},
+		"quoted query with flags": {
+			arguments: `"from:someone" --limit 50 --threads --force --keep-quotes`,
+			expected:  &bulkImportJob{Query: "from:someone", Limit: 50, Threads: true, Force: true, KeepQuotes: true},
+		},
+		"flags mixed with unquoted query": {
+			arguments: "--threads from:someone --limit 10 is:starred --force",
+			expected:  &bulkImportJob{Query: "from:someone is:starred", Limit: 10, Threads: true, Force: true},
+		},
+		"lowest limit": {
+			arguments: "from:someone --limit 1",
+			expected:  &bulkImportJob{Query: "from:someone", Limit: 1},
+		},
+		"highest limit": {
+			arguments: "from:someone --limit 1000",
+			expected:  &bulkImportJob{Query: "from:someone", Limit: bulkImportMaxLimit},
+		},
+		"limit too high": {
+			arguments:   "from:someone --limit 1001",
+			expectedErr: "The limit should be a number from 1 to 1000.",
+		},
+		"zero limit": {
+			arguments:   "from:someone --limit 0",
+			expectedErr: "The limit should be a number from 1 to 1000.",
+		},
+		"negative limit": {
+			arguments:   "from:someone --limit -5",
+			expectedErr: "The limit should be a number from 1 to 1000.",
+		},
+		"non-numeric limit": {
+			arguments:   "from:someone --limit ten",
+			expectedErr: "The limit should be a number from 1 to 1000.",
+		},
+		"missing limit": {
+			arguments:   "from:someone --limit",
+			expectedErr: "Please provide the number of mails to import after `--limit`.",
+		},
+		"no query": {
+			arguments:   "--limit 10 --threads",
+			expectedErr: "Please provide the query",
+		},
+		"empty arguments": {
+			arguments:   "",
+			expectedErr: "Please provide the query",
+		},
+		"empty quoted query": {
+			arguments:   `"" --force`,
+			expectedErr: "Please provide the query",
+		},
+		"blank quoted query": {
+			arguments:   `"   "`,
+			expectedErr: "Please provide the query",
+		},
+		"unterminated quote": {
+			arguments:   `"from:someone --limit 10`,
+			expectedErr: "Please provide the query",
+		},
+		"text after quoted query": {
+			arguments:   `"from:someone" is:unread`,
+			expectedErr: "Please provide the query",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			job, err := parseImportQueryArgs(test.arguments)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				assert.Nil(t, job)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, job)
+		})
+	}
+}
+
+func TestRunBulkImportJobLock(t *testing.T) {
+	for name, test := range map[string]struct {
+		acquired      bool
+		lockErr       *model.AppError
+		expectedRetry bool
+	}{
+		"lock acquired": {
+			acquired: true,
+		},
+		"lock held by another server": {
+			expectedRetry: true,
+		},
+		"lock could not be acquired": {
+			lockErr:       model.NewAppError("KVSetWithOptions", "", nil, "", 500),
+			expectedRetry: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			job := &bulkImportJob{ID: "job", UserID: "user", ChannelID: "channel", Query: "from:someone@example.com", IDs: []string{}}
+
+			api := &plugintest.API{}
+			api.On("KVSetWithOptions", bulkImportLockKeyPrefix+job.ID, mock.Anything, mock.Anything).Return(test.acquired, test.lockErr)
+			api.On("LogError", mock.Anything, mock.Anything, mock.Anything).Maybe()
+			if test.acquired {
+				api.On("KVGet", "usergmailID").Return([]byte("someone@gmail.com"), nil)
+				api.On("GetChannel", "channel").Return(&model.Channel{Name: "town-square", Type: model.CHANNEL_OPEN}, nil)
+				api.On("KVDelete", bulkImportJobKeyPrefix+job.ID).Return(nil)
+				api.On("KVDelete", bulkImportLockKeyPrefix+job.ID).Return(nil)
+			}
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			assert.Equal(t, test.expectedRetry, p.runBulkImportJob(job, make(chan bool)))
+			api.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStartBulkImportJobWhileStopped(t *testing.T) {
+	p := &Plugin{}
+	p.SetAPI(&plugintest.API{})
+
+	// The job is resumed on the activation of the plugin
+	p.startBulkImportJob(&bulkImportJob{ID: "job"})
+	p.stopBulkImportJobs()
+}
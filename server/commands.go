@@ -41,6 +41,14 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		return p.handleSubscriptionCommands(c, args, action)
 	case "subscriptions":
 		return p.handleListSubscriptionsCommand(c, args)
+	case "labels":
+		return p.handleLabelsCommand(c, args)
+	case "send":
+		return p.handleSendCommand(c, args)
+	case "search":
+		return p.handleSearchCommand(c, args)
+	case "unfollow":
+		return p.handleUnfollowCommand(c, args)
 	case "":
 		return p.handleHelpCommand(c, args)
 	case "help":
@@ -84,7 +92,7 @@ func (p *Plugin) handleDisconnectCommand(c *plugin.Context, args *model.CommandA
 		return &model.CommandResponse{}, nil
 	}
 
-	actionSecret := p.getConfiguration().EncryptionKey
+	actionSecret := p.getActionSecret()
 
 	deleteButton := &model.PostAction{
 		Type: model.POST_ACTION_TYPE_BUTTON,
@@ -137,7 +145,8 @@ func (p *Plugin) handleHelpCommand(c *plugin.Context, args *model.CommandArgs) (
 	return &model.CommandResponse{}, nil
 }
 
-// handleImportCommand handles the command `/gmail import thread [id]` and `/gmail import mail [id]`
+// handleImportCommand handles the command `/gmail import thread [id]` and `/gmail import mail [id]`,
+// where the ID can be a Gmail URL, a Gmail message or thread ID, or a Message-ID
 func (p *Plugin) handleImportCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 
 	if p.checkIfConnected(args.UserId) == false {
@@ -146,21 +155,35 @@ func (p *Plugin) handleImportCommand(c *plugin.Context, args *model.CommandArgs)
 	}
 
 	arguments := strings.Fields(args.Command)
+	options := importOptions{}
+	follow := false
+	if len(arguments) > 2 && arguments[2] != "query" {
+		arguments, options.Force = removeFlag(arguments, forceFlag)
+		arguments, follow = removeFlag(arguments, followFlag)
+		arguments, options.KeepQuotes = removeFlag(arguments, keepQuotesFlag)
+	}
 	// validate arguments of the command
 	if len(arguments) < 3 {
-		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please use `thread` or `mail` after `/gmail import`. Also provide the ID of thread/mail.")
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please use `thread` or `mail` after `/gmail import`, followed by the ID or Gmail URL of thread/mail. Or use `query` followed by the query of the mails to import.")
 		return &model.CommandResponse{}, nil
 	}
 	queryType := arguments[2]
+	if queryType == "query" {
+		return p.handleImportQueryCommand(c, args)
+	}
+	if follow && queryType != "thread" {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Only threads can be followed, using `/gmail import thread --follow`.")
+		return &model.CommandResponse{}, nil
+	}
 	if queryType != "thread" && queryType != "mail" {
-		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Only `thread` and `mail` are supported after `/gmail import`.")
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Only `thread`, `mail` and `query` are supported after `/gmail import`.")
 		return &model.CommandResponse{}, nil
 	}
 	if len(arguments) < 4 {
-		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the ID of "+arguments[2])
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the ID or Gmail URL of "+arguments[2])
 		return &model.CommandResponse{}, nil
 	}
-	rfcID := arguments[3]
+	reference := arguments[3]
 
 	gmailID, err := p.getGmailID(args.UserId)
 	if err != nil {
@@ -175,42 +198,52 @@ func (p *Plugin) handleImportCommand(c *plugin.Context, args *model.CommandArgs)
 	}
 	p.API.LogInfo("gmailService created successfully")
 
+	// The mail or thread can be referred to by a Gmail URL, a Gmail message or thread ID, or a Message-ID
+	mailRef, err := p.resolveMailReference(args.UserId, gmailID, reference)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
 	if queryType == "thread" {
-		threadID, threadIDErr := p.getThreadID(args.UserId, gmailID, rfcID)
-		if threadIDErr != nil {
-			p.sendMessageFromBot(args.ChannelId, args.UserId, true, threadIDErr.Error())
-			return &model.CommandResponse{}, nil
-		}
-		thread, threadErr := gmailService.Users.Threads.Get(gmailID, threadID).Format("minimal").Do()
-		if threadErr != nil {
-			p.sendMessageFromBot(args.ChannelId, args.UserId, true, threadErr.Error())
-			return &model.CommandResponse{}, nil
-		}
-		threadMessages := []*gmail.Message{}
-		for _, messageInfo := range thread.Messages {
-			message, mailErr := gmailService.Users.Messages.Get(gmailID, messageInfo.Id).Format("raw").Do()
-			if mailErr != nil {
+		threadID := mailRef.ThreadID
+		if threadID == "" {
+			message, messageErr := gmailService.Users.Messages.Get(gmailID, mailRef.MessageID).Format("minimal").Do()
+			if messageErr != nil {
 				p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to get the thread.")
 				return &model.CommandResponse{}, nil
 			}
-			threadMessages = append(threadMessages, message)
+			threadID = message.ThreadId
 		}
-		p.handleMessages(threadMessages, args.ChannelId, args.UserId, false)
+		threadMessages, threadErr := p.getRawThreadMessages(args.UserId, gmailID, threadID)
+		if threadErr != nil {
+			p.API.LogError("Could not fetch the thread", "err", threadErr.Error())
+			p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to get the thread.")
+			return &model.CommandResponse{}, nil
+		}
+		skippedPostIDs, _ := p.handleMessages(threadMessages, args.ChannelId, args.UserId, false, options)
+		p.sendAlreadyImportedMessage(args.ChannelId, args.UserId, skippedPostIDs, len(skippedPostIDs) == len(threadMessages))
 
+		if follow {
+			p.handleFollowThread(args.UserId, gmailID, threadID, args.ChannelId, threadMessages, options.KeepQuotes)
+		}
 		return &model.CommandResponse{}, nil
 	}
 	// if queryType == "mail" =>
 	// Note that explicit condition check is not required
 
-	messageID, err := p.getMessageID(args.UserId, gmailID, rfcID)
-	if err != nil {
-		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
-		p.API.LogInfo(err.Error())
-		return &model.CommandResponse{}, nil
+	messageID := mailRef.MessageID
+	if messageID == "" {
+		// Gmail URLs refer to threads, in which case the latest mail of the thread is imported
+		messageID, err = p.getLatestMessageIDOfThread(args.UserId, gmailID, mailRef.ThreadID)
+		if err != nil {
+			p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to get the mail.")
+			p.API.LogInfo(err.Error())
+			return &model.CommandResponse{}, nil
+		}
 	}
-	p.API.LogInfo("Extracted Message ID from rfc ID successfully")
 
-	message, err := gmailService.Users.Messages.Get(gmailID, messageID).Format("raw").Do()
+	message, err := p.getRawMessage(args.UserId, gmailID, messageID)
 	if err != nil {
 		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to get the mail.")
 		return &model.CommandResponse{}, nil
@@ -218,7 +251,8 @@ func (p *Plugin) handleImportCommand(c *plugin.Context, args *model.CommandArgs)
 	p.API.LogInfo("Message extracted successfully")
 
 	// Message extracted successfully
-	p.handleMessages([]*gmail.Message{message}, args.ChannelId, args.UserId, false)
+	skippedPostIDs, _ := p.handleMessages([]*gmail.Message{message}, args.ChannelId, args.UserId, false, options)
+	p.sendAlreadyImportedMessage(args.ChannelId, args.UserId, skippedPostIDs, true)
 
 	return &model.CommandResponse{}, nil
 }
@@ -238,56 +272,104 @@ func (p *Plugin) handleSubscriptionCommands(c *plugin.Context, args *model.Comma
 
 // handleSubscribeCommand updates the subscriptions of user in the KV Store
 func (p *Plugin) handleSubscribeCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
-	// `/gmail subscribe [LABELS for eg. INBOX, CATEGORY_PROMOTIONS]`
+	// `/gmail subscribe [LABELS for eg. INBOX, CATEGORY_PROMOTIONS, Customers/Escalations]`
 	// if no Label specified, assume all the supported labels
 
-	allLabelIDs := strings.TrimSpace(strings.ToUpper(strings.TrimPrefix(args.Command, "/"+commandGmail+" subscribe")))
-	labelIDs := p.getSupportedLabels()
+	allLabels := strings.TrimSpace(strings.TrimPrefix(args.Command, "/"+commandGmail+" subscribe"))
+	if strings.HasPrefix(allLabels, channelFlag) {
+		return p.handleChannelSubscribeCommand(c, args, strings.TrimSpace(strings.TrimPrefix(allLabels, channelFlag)))
+	}
+	labelIDsOrNames := p.getSupportedLabels()
 
-	if allLabelIDs != "" {
-		labelIDs = strings.Split(allLabelIDs, ",")
+	if allLabels != "" {
+		labelIDsOrNames = strings.Split(allLabels, ",")
 	}
 
-	for labelIndex, labelID := range labelIDs {
-		labelIDs[labelIndex] = strings.TrimSpace(labelID)
-		if _, ok := supportedLabelIDs[labelIDs[labelIndex]]; !ok {
-			p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Label ID: "+labelID+" not supported")
-			return &model.CommandResponse{}, nil
-		}
+	gmailID, err := p.getGmailID(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
+	labels, err := p.getLabels(args.UserId, gmailID)
+	if err != nil {
+		p.API.LogError("Could not fetch labels of the user", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your Gmail labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	resolvedLabels, unknownLabels := resolveLabels(labels, labelIDsOrNames)
+	if len(unknownLabels) > 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Label(s): "+strings.Join(unknownLabels, ", ")+" not found. The labels in your Gmail account are:\n"+formatLabelList(labels))
+		return &model.CommandResponse{}, nil
+	}
+	if len(resolvedLabels) == 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the label IDs or names to subscribe to (should be comma-separated).")
+		return &model.CommandResponse{}, nil
 	}
+	labelIDs := getLabelIDs(resolvedLabels)
 
-	p.updateSubscriptionsOfUser(args.UserId, labelIDs)
+	if err = p.subscribeToLabels(args.UserId, gmailID, labelIDs); err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to subscribe to the labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
 
-	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have subscribed to the labels: "+strings.Join(labelIDs, ",")+" successfully. Any previous subscription is overwritten.")
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have subscribed to the labels: "+strings.Join(getLabelDisplayNames(labels, labelIDs), ", ")+" successfully. Any previous subscription is overwritten.")
 	return &model.CommandResponse{}, nil
 }
 
 func (p *Plugin) handleUnsubscribeCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 
-	allLabelIDs := strings.TrimSpace(strings.ToUpper(strings.TrimPrefix(args.Command, "/"+commandGmail+" unsubscribe")))
+	allLabels := strings.TrimSpace(strings.TrimPrefix(args.Command, "/"+commandGmail+" unsubscribe"))
+	if strings.HasPrefix(allLabels, channelFlag) {
+		return p.handleChannelUnsubscribeCommand(c, args, strings.TrimSpace(strings.TrimPrefix(allLabels, channelFlag)))
+	}
 
-	labelIDs, _ := p.getSubscriptionsOfUser(args.UserId)
-	subscribedIDs := labelIDs
+	subscribedIDs, err := p.getSubscriptionsOfUser(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your subscriptions. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
 
 	// if not subscribed to any of the labelID
-	if len(labelIDs) == 0 {
+	if len(subscribedIDs) == 0 {
 		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have not subscribed to any label ID. Use `/"+commandGmail+" subscribe <Label IDs>` to subscribe.")
 		return &model.CommandResponse{}, nil
 	}
-	// get mentioned label IDs
-	if allLabelIDs != "" {
-		labelIDs = strings.Split(allLabelIDs, ",")
+
+	gmailID, err := p.getGmailID(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
+	labels, err := p.getLabels(args.UserId, gmailID)
+	if err != nil {
+		p.API.LogError("Could not fetch labels of the user", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your Gmail labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	// get mentioned label IDs, the label IDs are also matched as is in case the label is deleted
+	labelIDs := subscribedIDs
+	if allLabels != "" {
+		labelIDsOrNames := strings.Split(allLabels, ",")
+		resolvedLabels, _ := resolveLabels(labels, labelIDsOrNames)
+		labelIDs = getLabelIDs(resolvedLabels)
+		for _, labelIDOrName := range labelIDsOrNames {
+			labelIDs = append(labelIDs, strings.TrimSpace(labelIDOrName))
+		}
 	}
 
 	remainSubscribed := []string{}
-	unsubscribedTo := ""
+	unsubscribedFrom := []string{}
 
 	for _, subscribedID := range subscribedIDs {
 		// user is currently subscribed to subscribedID
 		foundInGivenIDs := false
 		for _, labelID := range labelIDs {
-			if strings.TrimSpace(labelID) == subscribedID {
-				unsubscribedTo += subscribedID + ", "
+			if labelID == subscribedID {
+				unsubscribedFrom = append(unsubscribedFrom, subscribedID)
 				foundInGivenIDs = true
 				break
 			}
@@ -296,26 +378,24 @@ func (p *Plugin) handleUnsubscribeCommand(c *plugin.Context, args *model.Command
 			remainSubscribed = append(remainSubscribed, subscribedID)
 		}
 	}
-	if unsubscribedTo == "" {
-		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have not been unsubscribed from any labels. Please check if you have specified correct label IDs.")
+	if len(unsubscribedFrom) == 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have not been unsubscribed from any labels. Please check if you have specified correct label IDs or names.")
 		return &model.CommandResponse{}, nil
 	}
 
-	p.updateSubscriptionsOfUser(args.UserId, remainSubscribed)
-	remainSubscribedMessage := ""
-	for labelIndex, labelID := range remainSubscribed {
-		remainSubscribedMessage += labelID
-		if labelIndex != len(remainSubscribed)-1 {
-			remainSubscribedMessage += ", "
-		}
+	if err = p.subscribeToLabels(args.UserId, gmailID, remainSubscribed); err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to unsubscribe from the labels. Please try again later.")
+		return &model.CommandResponse{}, nil
 	}
+
+	remainSubscribedMessage := strings.Join(getLabelDisplayNames(labels, remainSubscribed), ", ")
 	if remainSubscribedMessage != "" {
 		remainSubscribedMessage = "You are currently subscribed to the labels: " + remainSubscribedMessage
 	} else {
 		remainSubscribedMessage = "Currently, you have no active subscriptions"
 	}
 
-	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have successfully unsubscribed from the labels: "+unsubscribedTo+" .\n"+remainSubscribedMessage)
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have successfully unsubscribed from the labels: "+strings.Join(getLabelDisplayNames(labels, unsubscribedFrom), ", ")+".\n"+remainSubscribedMessage)
 	return &model.CommandResponse{}, nil
 }
 
@@ -325,14 +405,205 @@ func (p *Plugin) handleListSubscriptionsCommand(c *plugin.Context, args *model.C
 		return &model.CommandResponse{}, nil
 	}
 	subscriptions, _ := p.getSubscriptionsOfUser(args.UserId)
-	if len(subscriptions) == 0 {
+	channelSubscriptions, _ := p.getChannelSubscriptionsOfUser(args.UserId)
+	if len(subscriptions) == 0 && len(channelSubscriptions) == 0 {
 		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have not subscribed to any labels. Please use `/gmail subscribe <Label IDs>` to subscribe.")
 		return &model.CommandResponse{}, nil
 	}
-	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Currently, you are subscribed to the label IDs: "+strings.Join(subscriptions, ", "))
+
+	// Show the names of the labels if available
+	labels := []*gmail.Label{}
+	if gmailID, err := p.getGmailID(args.UserId); err == nil {
+		if userLabels, labelsErr := p.getLabels(args.UserId, gmailID); labelsErr == nil {
+			labels = userLabels
+		}
+	}
+
+	message := "Currently, you have not subscribed to any labels for direct messages."
+	if len(subscriptions) > 0 {
+		message = "Currently, you are subscribed to the labels: " + strings.Join(getLabelDisplayNames(labels, subscriptions), ", ")
+	}
+	for channelID, labelIDs := range channelSubscriptions {
+		channelName := channelID
+		if channel, appErr := p.API.GetChannel(channelID); appErr == nil {
+			channelName = "~" + channel.Name
+		}
+		message += "\n* " + channelName + " is subscribed to the labels: " + strings.Join(getLabelDisplayNames(labels, labelIDs), ", ")
+	}
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, message)
+	return &model.CommandResponse{}, nil
+}
+
+// handleChannelSubscribeCommand handles the command `/gmail subscribe --channel ~channel-name [labels]`
+func (p *Plugin) handleChannelSubscribeCommand(c *plugin.Context, args *model.CommandArgs, channelArgs string) (*model.CommandResponse, *model.AppError) {
+	channelName, allLabels := splitChannelArgs(channelArgs)
+	if channelName == "" {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the channel after `"+channelFlag+"`, eg. `/gmail subscribe "+channelFlag+" ~support-inbox INBOX`.")
+		return &model.CommandResponse{}, nil
+	}
+
+	channel, appErr := p.API.GetChannelByName(args.TeamId, channelName, false)
+	if appErr != nil || !p.API.HasPermissionToChannel(args.UserId, channel.Id, model.PERMISSION_CREATE_POST) {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Channel ~"+channelName+" not found or you are not allowed to post in it.")
+		return &model.CommandResponse{}, nil
+	}
+
+	// if no Label specified, assume INBOX
+	labelIDsOrNames := []string{"INBOX"}
+	if allLabels != "" {
+		labelIDsOrNames = strings.Split(allLabels, ",")
+	}
+
+	gmailID, err := p.getGmailID(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
+	labels, err := p.getLabels(args.UserId, gmailID)
+	if err != nil {
+		p.API.LogError("Could not fetch labels of the user", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your Gmail labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	resolvedLabels, unknownLabels := resolveLabels(labels, labelIDsOrNames)
+	if len(unknownLabels) > 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Label(s): "+strings.Join(unknownLabels, ", ")+" not found. The labels in your Gmail account are:\n"+formatLabelList(labels))
+		return &model.CommandResponse{}, nil
+	}
+	if len(resolvedLabels) == 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the label IDs or names to subscribe to (should be comma-separated).")
+		return &model.CommandResponse{}, nil
+	}
+	labelIDs := getLabelIDs(resolvedLabels)
+
+	if err = p.subscribeChannelToLabels(args.UserId, gmailID, channel.Id, labelIDs); err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to subscribe to the labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Mails from "+gmailID+" with the labels: "+strings.Join(getLabelDisplayNames(labels, labelIDs), ", ")+" will be posted in ~"+channel.Name+". Any previous subscription of the channel is overwritten.")
+	return &model.CommandResponse{}, nil
+}
+
+// handleChannelUnsubscribeCommand handles the command `/gmail unsubscribe --channel ~channel-name [labels]`
+func (p *Plugin) handleChannelUnsubscribeCommand(c *plugin.Context, args *model.CommandArgs, channelArgs string) (*model.CommandResponse, *model.AppError) {
+	channelName, allLabels := splitChannelArgs(channelArgs)
+	if channelName == "" {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the channel after `"+channelFlag+"`, eg. `/gmail unsubscribe "+channelFlag+" ~support-inbox`.")
+		return &model.CommandResponse{}, nil
+	}
+
+	channelSubscriptions, err := p.getChannelSubscriptionsOfUser(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your subscriptions. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	channel, appErr := p.API.GetChannelByName(args.TeamId, channelName, true)
+	if appErr != nil || len(channelSubscriptions[channel.Id]) == 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You have not subscribed to any label for the channel ~"+channelName+".")
+		return &model.CommandResponse{}, nil
+	}
+	subscribedIDs := channelSubscriptions[channel.Id]
+
+	gmailID, err := p.getGmailID(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
+	// if no Label specified, unsubscribe the channel from all the labels
+	remainSubscribed := []string{}
+	if allLabels != "" {
+		labels, labelsErr := p.getLabels(args.UserId, gmailID)
+		if labelsErr != nil {
+			p.API.LogError("Could not fetch labels of the user", "err", labelsErr.Error())
+			p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your Gmail labels. Please try again later.")
+			return &model.CommandResponse{}, nil
+		}
+
+		labelIDsOrNames := strings.Split(allLabels, ",")
+		resolvedLabels, _ := resolveLabels(labels, labelIDsOrNames)
+		labelIDs := getLabelIDs(resolvedLabels)
+		for _, labelIDOrName := range labelIDsOrNames {
+			labelIDs = append(labelIDs, strings.TrimSpace(labelIDOrName))
+		}
+
+		for _, subscribedID := range subscribedIDs {
+			foundInGivenIDs := false
+			for _, labelID := range labelIDs {
+				if labelID == subscribedID {
+					foundInGivenIDs = true
+					break
+				}
+			}
+			if !foundInGivenIDs {
+				remainSubscribed = append(remainSubscribed, subscribedID)
+			}
+		}
+		if len(remainSubscribed) == len(subscribedIDs) {
+			p.sendMessageFromBot(args.ChannelId, args.UserId, true, "The channel ~"+channel.Name+" has not been unsubscribed from any labels. Please check if you have specified correct label IDs or names.")
+			return &model.CommandResponse{}, nil
+		}
+	}
+
+	if err = p.subscribeChannelToLabels(args.UserId, gmailID, channel.Id, remainSubscribed); err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to unsubscribe from the labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	if len(remainSubscribed) == 0 {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Mails will no longer be posted in ~"+channel.Name+".")
+		return &model.CommandResponse{}, nil
+	}
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "The channel ~"+channel.Name+" is now subscribed to the label IDs: "+strings.Join(remainSubscribed, ", "))
 	return &model.CommandResponse{}, nil
 }
 
+// splitChannelArgs splits the arguments following the channel flag into the channel name and the labels
+func splitChannelArgs(channelArgs string) (string, string) {
+	fields := strings.Fields(channelArgs)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	channelName := strings.TrimPrefix(fields[0], "~")
+	return channelName, strings.TrimSpace(strings.TrimPrefix(channelArgs, fields[0]))
+}
+
+// handleLabelsCommand lists the labels of the user with buttons to subscribe or unsubscribe to them
+func (p *Plugin) handleLabelsCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if p.checkIfConnected(args.UserId) == false {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "You are not currently connected with Gmail. Use `/gmail connect` to get connected.")
+		return &model.CommandResponse{}, nil
+	}
+
+	labelsPost, err := p.getLabelsPost(args.UserId, args.ChannelId)
+	if err != nil {
+		p.API.LogError("Could not fetch labels of the user", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to fetch your Gmail labels. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+
+	p.API.SendEphemeralPost(args.UserId, labelsPost)
+	return &model.CommandResponse{}, nil
+}
+
+// removeFlag removes the flag from the arguments of the command, returning whether it was present
+func removeFlag(arguments []string, flag string) ([]string, bool) {
+	remaining := []string{}
+	found := false
+	for _, argument := range arguments {
+		if argument == flag {
+			found = true
+			continue
+		}
+		remaining = append(remaining, argument)
+	}
+	return remaining, found
+}
+
 // handleInvalidCommand
 func (p *Plugin) handleInvalidCommand(c *plugin.Context, args *model.CommandArgs, action string) (*model.CommandResponse, *model.AppError) {
 	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "##### Unknown Command: "+action+"\n"+helpTextHeader+commonHelpText)
@@ -19,10 +19,19 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	GmailOAuthClientID string
-	GmailOAuthSecret   string
-	TopicName          string
-	EncryptionKey      string
+	GmailOAuthClientID          string
+	GmailOAuthSecret            string
+	TopicName                   string
+	WebhookAuthenticationType   string
+	PubSubServiceAccountEmail   string
+	PubSubAudience              string
+	WebhookSecret               string
+	EncryptionKey               string
+	LongMailHandling            string
+	MaxAttachmentSize           string
+	AllowedAttachmentExtensions string
+	BlockedAttachmentExtensions string
+	ShowLinkTargets             bool
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -47,6 +56,22 @@ func (c *configuration) IsValid() error {
 		return fmt.Errorf("Must have Topic Name entered in plugin settings")
 	}
 
+	switch c.WebhookAuthenticationType {
+	case webhookAuthTypeJWT:
+		if c.PubSubServiceAccountEmail == "" {
+			return fmt.Errorf("Must have Pub/Sub Service Account Email entered in plugin settings to authenticate webhooks using JWT")
+		}
+	case webhookAuthTypeToken:
+		if c.WebhookSecret == "" {
+			return fmt.Errorf("Must have Webhook Secret generated in plugin settings to authenticate webhooks using secret token")
+		}
+	case "":
+		// The setting is empty for installs upgraded from versions without it until the settings are saved.
+		// The secret token is used then, the notifications being rejected until the Webhook Secret is generated.
+	default:
+		return fmt.Errorf("Must have Webhook Authentication selected in plugin settings")
+	}
+
 	if c.EncryptionKey == "" {
 		return fmt.Errorf("Must have Encryption Key generated in plugin settings")
 	}
@@ -54,6 +79,15 @@ func (c *configuration) IsValid() error {
 	return nil
 }
 
+// getWebhookAuthenticationType returns how the notifications pushed to the webhook are authenticated,
+// using the secret token if not selected
+func (c *configuration) getWebhookAuthenticationType() string {
+	if c.WebhookAuthenticationType == "" {
+		return webhookAuthTypeToken
+	}
+	return c.WebhookAuthenticationType
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
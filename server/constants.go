@@ -7,15 +7,24 @@ const (
 
 // specific to plugin sub-commands
 const (
+	channelFlag = "--channel"
+
 	helpTextHeader = "###### Mattermost Gmail Plugin - Slash Command Help\n"
 
 	commonHelpText = "\n* `/gmail connect` - Connect your Mattermost account to your Gmail account\n" +
 		"* `/gmail disconnect` - Disconnect Gmail from Mattermost\n" +
-		"* `/gmail import mail <message-id>` - Import a mail/message from Gmail using message ID.\n\nNote: To get ID of any mail, click on the 3 dots after opening the mail, and then select 'Show Original'. You will see the Message ID at the top in a new tab\n" +
-		"* `/gmail import thread <thread-message-id>` - Import a complete Gmail thread (conversation) using ID of any mail in the thread\n" +
-		"* `/gmail subscribe <optional-label-ids>` - Subscribe to get notifications from the Gmail Bot for the labels mentioned. Mention the label IDs in comma-separated fashion from the list: INBOX, CATEGORY_PERSONAL, CATEGORY_SOCIAL, CATEGORY_PROMOTIONS, CATEGORY_UPDATES, CATEGORY_FORUMS. The default label is INBOX.\n" +
-		"* `/gmail unsubscribe <optional-label-ids>` - Unsubscribe from the mentioned labels (should be comma-separated). If none is mentioned, you'll be unsubscribed from all the label IDs. It might take a few minutes for the effect to take place.\n" +
+		"* `/gmail import mail <gmail-url or message-id>` - Import a mail/message from Gmail using the URL of the mail opened in Gmail, its Message-ID or its Gmail message ID. If the URL is of a conversation, the latest mail in it is imported. A mail is imported only once in a channel unless `--force` is used. The quoted reply history is hidden unless `--keep-quotes` is used.\n\nNote: To get the Message-ID of any mail, click on the 3 dots after opening the mail, and then select 'Show Original'. You will see the Message ID at the top in a new tab\n" +
+		"* `/gmail import thread <gmail-url or message-id>` - Import a complete Gmail thread (conversation) using its URL in Gmail, its Gmail thread ID, or the ID of any mail in the thread. With `--follow`, the new mails of the thread are added to the imported thread. With `--keep-quotes`, the quoted reply history of each mail is kept\n" +
+		"* `/gmail unfollow <gmail-url or thread-id>` - Stop adding the new mails of a followed thread to the channel\n" +
+		"* `/gmail import query \"<gmail-query>\" <optional --limit N> <optional --threads> <optional --force> <optional --keep-quotes>` - Import the latest mails matching the query (100 by default, at most 1000) in chronological order, in the background. With `--threads`, the complete threads of the mails are imported\n" +
+		"* `/gmail search <gmail-query>` - Search your mails using the Gmail search syntax, eg. `from:someone@example.com has:attachment`, and import the mails or threads found\n" +
+		"* `/gmail send <optional-recipients>` - Compose and send a mail from your Gmail account. The body of the mail can be written in markdown\n" +
+		"* `/gmail subscribe <optional-labels>` - Subscribe to get notifications from the Gmail Bot for the labels mentioned. Mention the label IDs or names in comma-separated fashion, eg. INBOX, CATEGORY_UPDATES, Customers/Escalations. The default labels are INBOX, CATEGORY_PERSONAL, CATEGORY_SOCIAL, CATEGORY_PROMOTIONS, CATEGORY_UPDATES and CATEGORY_FORUMS.\n" +
+		"* `/gmail unsubscribe <optional-labels>` - Unsubscribe from the mentioned labels (should be comma-separated). If none is mentioned, you'll be unsubscribed from all the label IDs.\n" +
+		"* `/gmail subscribe --channel ~channel-name <optional-labels>` - Post mails with the labels mentioned in the channel instead of the direct message. The default label is INBOX.\n" +
+		"* `/gmail unsubscribe --channel ~channel-name <optional-labels>` - Stop posting mails with the labels mentioned in the channel. If none is mentioned, the channel is unsubscribed from all the labels.\n" +
 		"* `/gmail subscriptions` - Display label IDs currently subscribed to\n" +
+		"* `/gmail labels` - List the labels in your Gmail account with their message counts, and subscribe or unsubscribe to them\n" +
 		"* `/gmail help` - Display help about this plugin"
 )
 
@@ -24,6 +33,30 @@ const (
 	ActionDisconnectPlugin = "ActionDisconnectPlugin"
 	// ActionCancel can be used in any Post action to identify cancel action
 	ActionCancel = "ActionCancel"
+	// ActionSubscribeLabel is used in Post action to identify subscribe button action of a label
+	ActionSubscribeLabel = "ActionSubscribeLabel"
+	// ActionUnsubscribeLabel is used in Post action to identify unsubscribe button action of a label
+	ActionUnsubscribeLabel = "ActionUnsubscribeLabel"
+	// ActionSendReply is used in Post action to identify the confirmation to send a reply in a thread as an email
+	ActionSendReply = "ActionSendReply"
+	// ActionImportMail is used in Post action to identify the import of a mail from the search results
+	ActionImportMail = "ActionImportMail"
+	// ActionImportThread is used in Post action to identify the import of a thread from the search results
+	ActionImportThread = "ActionImportThread"
+	// ActionSearchMore is used in Post action to identify the request for the next page of the search results
+	ActionSearchMore = "ActionSearchMore"
+	// ActionFetchAttachment is used in Post action to identify the request to fetch an attachment not uploaded with its mail
+	ActionFetchAttachment = "ActionFetchAttachment"
+	// ActionShowQuotedText is used in Post action to identify the request to show the quoted reply history of a mail
+	ActionShowQuotedText = "ActionShowQuotedText"
+	// ActionHideQuotedText is used in Post action to identify the request to hide the quoted reply history of a mail
+	ActionHideQuotedText = "ActionHideQuotedText"
+)
+
+// webhook authentication types
+const (
+	webhookAuthTypeJWT   = "jwt"
+	webhookAuthTypeToken = "token"
 )
 
 // specific to scope required
@@ -31,7 +64,7 @@ const (
 	emailScope = "https://www.googleapis.com/auth/userinfo.email"
 )
 
-// label IDs supported
+// label IDs subscribed by default
 // Note: supportedLabelIDs used as set data structure
 var supportedLabelIDs = map[string]int{
 	/* Label */            /* Any valid int*/
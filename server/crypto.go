@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// encrypt seals the plain text using AES-GCM with a key derived from the given secret
+// and returns the nonce followed by the cipher text, encoded in base64
+func encrypt(secret string, plainText []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "could not generate nonce")
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plainText, nil)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+	return encoded, nil
+}
+
+// decrypt reverses encrypt, returning an error if the data was not sealed using the given secret
+func decrypt(secret string, encoded []byte) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	length, err := base64.StdEncoding.Decode(sealed, encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode encrypted data")
+	}
+	sealed = sealed[:length]
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	nonce, cipherText := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plainText, err := gcm.Open(nil, nonce, cipherText, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decrypt data")
+	}
+	return plainText, nil
+}
+
+// deriveActionSecret derives the secret passed in the context of the buttons of the plugin from the given secret,
+// so that the secret used to encrypt the tokens is never stored in the posts
+func deriveActionSecret(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("actionSecret"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newGCM creates the AES-GCM cipher. The secret is hashed so that keys of any length can be used
+func newGCM(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, errors.New("encryption key is not set")
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create cipher")
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/gmail/v1"
+)
+
+// followFlag is used to follow a thread while importing it
+const followFlag = "--follow"
+
+// followedThreadLabels are the labels watched for new messages in the threads followed by a user,
+// as replies are received in the inbox and sent by the user
+var followedThreadLabels = []string{"INBOX", "SENT"}
+
+// followedThread is a Mattermost thread to which the new messages of a followed Gmail thread are added
+type followedThread struct {
+	ChannelID string
+	RootID    string
+	// KeepQuotes is set if the quoted reply history of the new messages is kept, as when the thread was imported
+	KeepQuotes bool
+}
+
+// getFollowedThreadsOfUser returns the threads followed by the user, keyed by Gmail thread ID
+func (p *Plugin) getFollowedThreadsOfUser(userID string) (map[string][]followedThread, error) {
+	followedThreads := map[string][]followedThread{}
+	followedThreadsJSON, appErr := p.API.KVGet(userID + "followedThreads")
+	if appErr != nil {
+		return nil, appErr
+	}
+	if followedThreadsJSON == nil {
+		return followedThreads, nil
+	}
+
+	if err := json.Unmarshal(followedThreadsJSON, &followedThreads); err != nil {
+		return nil, err
+	}
+	return followedThreads, nil
+}
+
+// updateFollowedThreadsOfUser stores the threads followed by the user
+func (p *Plugin) updateFollowedThreadsOfUser(userID string, followedThreads map[string][]followedThread) error {
+	if len(followedThreads) == 0 {
+		if appErr := p.API.KVDelete(userID + "followedThreads"); appErr != nil {
+			return appErr
+		}
+		return nil
+	}
+
+	followedThreadsJSON, err := json.Marshal(followedThreads)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(userID+"followedThreads", followedThreadsJSON); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// followThread adds the new messages of the Gmail thread to the thread with the root post in the channel,
+// watching the mailbox for them if not done already
+func (p *Plugin) followThread(userID string, gmailID string, threadID string, channelID string, rootID string, keepQuotes bool) error {
+	followedThreads, err := p.getFollowedThreadsOfUser(userID)
+	if err != nil {
+		return err
+	}
+
+	threads := []followedThread{{ChannelID: channelID, RootID: rootID, KeepQuotes: keepQuotes}}
+	for _, thread := range followedThreads[threadID] {
+		if thread.ChannelID != channelID {
+			threads = append(threads, thread)
+		}
+	}
+	followedThreads[threadID] = threads
+
+	if err = p.updateFollowedThreadsOfUser(userID, followedThreads); err != nil {
+		return err
+	}
+	return p.refreshWatchOfUser(userID, gmailID)
+}
+
+// handleFollowThread follows the imported thread in the channel, adding the new messages to the thread of its first message
+func (p *Plugin) handleFollowThread(userID string, gmailID string, threadID string, channelID string, threadMessages []*gmail.Message, keepQuotes bool) {
+	if len(threadMessages) == 0 {
+		return
+	}
+
+	imported, err := p.getImportedMessage(channelID, threadMessages[0].Id)
+	if err != nil || imported == nil {
+		p.sendMessageFromBot(channelID, userID, true, "Unable to follow the thread as it could not be imported.")
+		return
+	}
+
+	if err = p.followThread(userID, gmailID, threadID, channelID, imported.RootID, keepQuotes); err != nil {
+		p.API.LogError("Could not follow the thread", "err", err.Error())
+		p.sendMessageFromBot(channelID, userID, true, "Unable to follow the thread. Please try again later.")
+		return
+	}
+	p.sendMessageFromBot(channelID, userID, true, "New mails in the thread will be added to the imported thread. Use `/gmail unfollow` to stop.")
+}
+
+// unfollowThread stops adding the new messages of the Gmail thread to the channel, returning whether it was followed
+func (p *Plugin) unfollowThread(userID string, gmailID string, threadID string, channelID string) (bool, error) {
+	followedThreads, err := p.getFollowedThreadsOfUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	threads := []followedThread{}
+	for _, thread := range followedThreads[threadID] {
+		if thread.ChannelID != channelID {
+			threads = append(threads, thread)
+		}
+	}
+	if len(threads) == len(followedThreads[threadID]) {
+		return false, nil
+	}
+	if len(threads) == 0 {
+		delete(followedThreads, threadID)
+	} else {
+		followedThreads[threadID] = threads
+	}
+
+	if err = p.updateFollowedThreadsOfUser(userID, followedThreads); err != nil {
+		return false, err
+	}
+	if len(followedThreads) == 0 {
+		// Stop watching the labels only watched for the followed threads
+		if _, err = p.updateWatchForGmail(userID, gmailID); err != nil {
+			p.API.LogError("Could not update Gmail watch after unfollowing the thread", "err", err.Error())
+		}
+	}
+	return true, nil
+}
+
+// postFollowedThreadMessages adds the messages belonging to the threads followed by the user to the followed threads,
+// returning the IDs of the messages added to at least one of them
+func (p *Plugin) postFollowedThreadMessages(userID string, messages []*gmail.Message) (map[string]bool, error) {
+	delivered := map[string]bool{}
+	followedThreads, err := p.getFollowedThreadsOfUser(userID)
+	if err != nil {
+		return delivered, errors.Wrap(err, "could not fetch followed threads of the user")
+	}
+	if len(followedThreads) == 0 {
+		return delivered, nil
+	}
+
+	changed := false
+	failed := false
+	for _, message := range messages {
+		unfollowed := false
+		threads := []followedThread{}
+		for _, thread := range followedThreads[message.ThreadId] {
+			// Stop following the thread if its root post was deleted or the user can no longer post in the channel
+			if _, appErr := p.API.GetPost(thread.RootID); appErr != nil || !p.API.HasPermissionToChannel(userID, thread.ChannelID, model.PERMISSION_CREATE_POST) {
+				p.API.LogInfo("Unfollowing the thread with root ID: " + thread.RootID + " for the user with user ID: " + userID)
+				unfollowed = true
+				continue
+			}
+			threads = append(threads, thread)
+
+			if _, err = p.handleMessages([]*gmail.Message{message}, thread.ChannelID, userID, false, importOptions{RootID: thread.RootID, KeepQuotes: thread.KeepQuotes}); err != nil {
+				p.API.LogError("Could not post the message with message ID: "+message.Id+" in the followed thread with root ID: "+thread.RootID, "err", err.Error())
+				failed = true
+				continue
+			}
+			delivered[message.Id] = true
+		}
+
+		if unfollowed {
+			if len(threads) == 0 {
+				delete(followedThreads, message.ThreadId)
+			} else {
+				followedThreads[message.ThreadId] = threads
+			}
+			changed = true
+		}
+	}
+
+	if changed {
+		if err = p.updateFollowedThreadsOfUser(userID, followedThreads); err != nil {
+			return delivered, err
+		}
+	}
+	if failed {
+		return delivered, errors.New("some messages could not be added to the followed threads")
+	}
+	return delivered, nil
+}
+
+// handleUnfollowCommand handles the command `/gmail unfollow <gmail-url or id>`
+func (p *Plugin) handleUnfollowCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if p.checkIfConnected(args.UserId) == false {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please connect yourself to Gmail using `/gmail connect`.")
+		return &model.CommandResponse{}, nil
+	}
+
+	reference := strings.TrimSpace(strings.TrimPrefix(args.Command, "/"+commandGmail+" unfollow"))
+	if reference == "" {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the ID or Gmail URL of the thread to unfollow.")
+		return &model.CommandResponse{}, nil
+	}
+
+	gmailID, err := p.getGmailID(args.UserId)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
+	threadID, err := p.resolveThreadID(args.UserId, gmailID, reference)
+	if err != nil {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, err.Error())
+		return &model.CommandResponse{}, nil
+	}
+
+	unfollowed, err := p.unfollowThread(args.UserId, gmailID, threadID, args.ChannelId)
+	if err != nil {
+		p.API.LogError("Could not unfollow the thread", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to unfollow the thread. Please try again later.")
+		return &model.CommandResponse{}, nil
+	}
+	if !unfollowed {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "The thread is not followed in this channel.")
+		return &model.CommandResponse{}, nil
+	}
+
+	p.sendMessageFromBot(args.ChannelId, args.UserId, true, "New mails in the thread will no longer be added to this channel.")
+	return &model.CommandResponse{}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+func TestGetMessagesAddedSince(t *testing.T) {
+	for name, test := range map[string]struct {
+		messageStatus      map[string]int
+		historyStatus      int
+		expectedMessageIDs []string
+		expectedHistoryID  uint64
+		expectedErr        bool
+	}{
+		"all messages fetched": {
+			expectedMessageIDs: []string{"m1", "m2"},
+			expectedHistoryID:  120,
+		},
+		"deleted message skipped": {
+			messageStatus:      map[string]int{"m1": http.StatusNotFound},
+			expectedMessageIDs: []string{"m2"},
+			expectedHistoryID:  120,
+		},
+		"server error": {
+			messageStatus: map[string]int{"m2": http.StatusInternalServerError},
+			expectedErr:   true,
+		},
+		"rate limited": {
+			messageStatus: map[string]int{"m1": http.StatusTooManyRequests},
+			expectedErr:   true,
+		},
+		"expired history ID": {
+			historyStatus: http.StatusNotFound,
+			expectedErr:   true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.HasSuffix(r.URL.Path, "/history") {
+					if test.historyStatus != 0 {
+						w.WriteHeader(test.historyStatus)
+						w.Write([]byte(`{"error": {"code": 404, "message": "Requested entity was not found."}}`))
+						return
+					}
+					w.Write([]byte(`{"historyId": "120", "history": [
+						{"messagesAdded": [{"message": {"id": "m1"}}, {"message": {"id": "m2"}}]},
+						{"messagesAdded": [{"message": {"id": "m1"}}]}
+					]}`))
+					return
+				}
+
+				messageID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+				if status := test.messageStatus[messageID]; status != 0 {
+					w.WriteHeader(status)
+					w.Write([]byte(`{"error": {"message": "failed"}}`))
+					return
+				}
+				w.Write([]byte(`{"id": "` + messageID + `"}`))
+			}))
+			defer server.Close()
+
+			gmailService, err := gmail.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+			require.NoError(t, err)
+
+			api := &plugintest.API{}
+			api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything).Maybe()
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			messages, historyID, err := p.getMessagesAddedSince(gmailService, "someone@gmail.com", 100)
+			if test.expectedErr {
+				require.Error(t, err)
+				// Only an expired history ID resets the history ID of the user
+				assert.Equal(t, test.historyStatus == http.StatusNotFound, isNotFound(err))
+				return
+			}
+			require.NoError(t, err)
+			messageIDs := []string{}
+			for _, message := range messages {
+				messageIDs = append(messageIDs, message.Id)
+			}
+			assert.Equal(t, test.expectedMessageIDs, messageIDs)
+			assert.Equal(t, test.expectedHistoryID, historyID)
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// gmailAPIIDPattern matches the hexadecimal message and thread IDs used by the Gmail API and older Gmail web URLs
+var gmailAPIIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{12,20}$`)
+
+// gmailWebTokenPattern matches the message and thread tokens used by newer Gmail web URLs
+var gmailWebTokenPattern = regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZbcdfghjklmnpqrstvwxz]{20,}$`)
+
+// gmailWebTokenCharset is the alphabet of the tokens used by newer Gmail web URLs
+const gmailWebTokenCharset = "BCDFGHJKLMNPQRSTVWXZbcdfghjklmnpqrstvwxz"
+
+// base64Charset is the alphabet of standard base64 encoding
+const base64Charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// forceFlag is used to import mails again in a channel they were imported in before
+const forceFlag = "--force"
+
+// keepQuotesFlag is used to keep the quoted reply history in the imported mails
+const keepQuotesFlag = "--keep-quotes"
+
+// importOptions changes how the messages are imported by handleMessages
+type importOptions struct {
+	// Force imports the messages even if they were imported in the channel before
+	Force bool
+	// RootID adds the messages to the thread with the root post, instead of starting a new thread
+	RootID string
+	// KeepQuotes keeps the quoted reply history in the body of the messages, instead of hiding it
+	KeepQuotes bool
+}
+
+// importedMessageExpiry is the period after which the record of an imported message is deleted,
+// as it is not deleted along with its posts
+const importedMessageExpiry = 365 * 24 * time.Hour
+
+// importedMessage records the posts created for a Gmail message imported in a channel
+type importedMessage struct {
+	RootID  string
+	PostIDs []string
+}
+
+// mailReference identifies the Gmail message or thread referred to by the user. Only the thread ID is known for thread references.
+type mailReference struct {
+	MessageID string
+	ThreadID  string
+}
+
+// resolveMailReference finds the Gmail message or thread referred to by a Gmail web URL, a Gmail API message or thread ID,
+// or an RFC 822 Message-ID, with or without angle brackets
+func (p *Plugin) resolveMailReference(userID string, gmailID string, reference string) (*mailReference, error) {
+	reference = strings.TrimSpace(reference)
+
+	if strings.Contains(reference, "mail.google.com") || strings.Contains(reference, "://") {
+		return parseGmailURL(reference)
+	}
+
+	if rfcID := strings.Trim(reference, "<>"); strings.Contains(rfcID, "@") {
+		message, err := p.findMessageByRFCID(userID, gmailID, rfcID)
+		if err != nil {
+			return nil, err
+		}
+		if message == nil {
+			return nil, errors.New("Invalid ID. No mail with the Message-ID " + rfcID + " was found")
+		}
+		return &mailReference{MessageID: message.Id, ThreadID: message.ThreadId}, nil
+	}
+
+	if gmailAPIIDPattern.MatchString(reference) {
+		return p.resolveGmailAPIID(userID, gmailID, strings.ToLower(reference))
+	}
+
+	if gmailWebTokenPattern.MatchString(reference) {
+		return decodeGmailWebToken(reference)
+	}
+	return nil, errors.New("Invalid ID. Please provide a Gmail URL, a Message-ID from 'Show Original' or a Gmail message ID")
+}
+
+// resolveThreadID finds the ID of the Gmail thread referred to, or of the thread of the message referred to
+func (p *Plugin) resolveThreadID(userID string, gmailID string, reference string) (string, error) {
+	mailRef, err := p.resolveMailReference(userID, gmailID, reference)
+	if err != nil {
+		return "", err
+	}
+	if mailRef.ThreadID != "" {
+		return mailRef.ThreadID, nil
+	}
+
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return "", err
+	}
+	message, err := gmailService.Users.Messages.Get(gmailID, mailRef.MessageID).Format("minimal").Do()
+	if err != nil {
+		return "", err
+	}
+	return message.ThreadId, nil
+}
+
+// resolveGmailAPIID finds out if the Gmail API ID identifies a message or a thread
+func (p *Plugin) resolveGmailAPIID(userID string, gmailID string, id string) (*mailReference, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := gmailService.Users.Messages.Get(gmailID, id).Format("minimal").Do()
+	if err == nil {
+		return &mailReference{MessageID: message.Id, ThreadID: message.ThreadId}, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	thread, err := gmailService.Users.Threads.Get(gmailID, id).Format("minimal").Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errors.New("Invalid ID. No mail or thread with the ID " + id + " was found")
+		}
+		return nil, err
+	}
+	return &mailReference{ThreadID: thread.Id}, nil
+}
+
+// findMessageByRFCID searches the mailbox for the message with the RFC 822 Message-ID, nil if there is not exactly one
+func (p *Plugin) findMessageByRFCID(userID string, gmailID string, rfcID string) (*gmail.Message, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	listResponse, err := gmailService.Users.Messages.List(gmailID).Q("rfc822msgid:" + strings.Trim(rfcID, "<>")).Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(listResponse.Messages) != 1 {
+		return nil, nil
+	}
+	return listResponse.Messages[0], nil
+}
+
+// getLatestMessageIDOfThread returns the ID of the latest message in the thread
+func (p *Plugin) getLatestMessageIDOfThread(userID string, gmailID string, threadID string) (string, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return "", err
+	}
+
+	thread, err := gmailService.Users.Threads.Get(gmailID, threadID).Format("minimal").Do()
+	if err != nil {
+		return "", err
+	}
+	if len(thread.Messages) == 0 {
+		return "", errors.New("The thread has no mails")
+	}
+	return thread.Messages[len(thread.Messages)-1].Id, nil
+}
+
+// getRawMessage fetches the message in the raw format, as required by handleMessages
+func (p *Plugin) getRawMessage(userID string, gmailID string, messageID string) (*gmail.Message, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+	return gmailService.Users.Messages.Get(gmailID, messageID).Format("raw").Do()
+}
+
+// getRawThreadMessages fetches the messages of the thread in the raw format, as required by handleMessages
+func (p *Plugin) getRawThreadMessages(userID string, gmailID string, threadID string) ([]*gmail.Message, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, err := gmailService.Users.Threads.Get(gmailID, threadID).Format("minimal").Do()
+	if err != nil {
+		return nil, err
+	}
+
+	threadMessages := []*gmail.Message{}
+	for _, messageInfo := range thread.Messages {
+		message, err := gmailService.Users.Messages.Get(gmailID, messageInfo.Id).Format("raw").Do()
+		if err != nil {
+			return nil, err
+		}
+		threadMessages = append(threadMessages, message)
+	}
+	return threadMessages, nil
+}
+
+// storeImportedMessage records the posts created for the message imported in the channel
+func (p *Plugin) storeImportedMessage(channelID string, messageID string, imported *importedMessage) error {
+	importedJSON, err := json.Marshal(imported)
+	if err != nil {
+		return err
+	}
+	if _, appErr := p.API.KVSetWithOptions(channelID+messageID+"imported", importedJSON, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(importedMessageExpiry / time.Second),
+	}); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getImportedMessage returns the posts created for the message imported in the channel,
+// nil if the message was not imported or its first post or the root of its thread has been deleted
+func (p *Plugin) getImportedMessage(channelID string, messageID string) (*importedMessage, error) {
+	importedJSON, appErr := p.API.KVGet(channelID + messageID + "imported")
+	if appErr != nil {
+		return nil, appErr
+	}
+	if importedJSON == nil {
+		return nil, nil
+	}
+
+	var imported importedMessage
+	if err := json.Unmarshal(importedJSON, &imported); err != nil {
+		return nil, err
+	}
+	if len(imported.PostIDs) == 0 {
+		return nil, nil
+	}
+	postIDs := []string{imported.PostIDs[0]}
+	if imported.RootID != "" && imported.RootID != imported.PostIDs[0] {
+		postIDs = append(postIDs, imported.RootID)
+	}
+	for _, postID := range postIDs {
+		if _, appErr = p.API.GetPost(postID); appErr != nil {
+			p.API.KVDelete(channelID + messageID + "imported")
+			return nil, nil
+		}
+	}
+	return &imported, nil
+}
+
+// sendAlreadyImportedMessage lets the user know that the mails were imported in the channel before, linking to their posts
+func (p *Plugin) sendAlreadyImportedMessage(channelID string, userID string, skippedPostIDs []string, importedAll bool) {
+	if len(skippedPostIDs) == 0 {
+		return
+	}
+
+	links := []string{}
+	for _, postID := range skippedPostIDs {
+		links = append(links, p.getPermalink(channelID, userID, postID))
+	}
+
+	message := "The mail was already imported in this channel: " + strings.Join(links, ", ")
+	if len(skippedPostIDs) > 1 {
+		message = "Some of the mails were already imported in this channel: " + strings.Join(links, ", ")
+		if importedAll {
+			message = "The mails were already imported in this channel: " + strings.Join(links, ", ")
+		}
+	}
+	p.sendMessageFromBot(channelID, userID, true, message+"\nUse `/gmail import` with `"+forceFlag+"` to import again.")
+}
+
+// getPermalink returns the permalink of the post in the channel, using a team of the user for channels without a team
+func (p *Plugin) getPermalink(channelID string, userID string, postID string) string {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+
+	teamID := ""
+	if channel, appErr := p.API.GetChannel(channelID); appErr == nil {
+		teamID = channel.TeamId
+	}
+	if teamID == "" {
+		if teams, appErr := p.API.GetTeamsForUser(userID); appErr == nil && len(teams) > 0 {
+			teamID = teams[0].Id
+		}
+	}
+
+	team, appErr := p.API.GetTeam(teamID)
+	if appErr != nil {
+		return postID
+	}
+	return fmt.Sprintf("%s/%s/pl/%s", siteURL, team.Name, postID)
+}
+
+// parseGmailURL extracts the thread or message from a Gmail web URL such as https://mail.google.com/mail/u/0/#inbox/<id>
+func parseGmailURL(gmailURL string) (*mailReference, error) {
+	if !strings.Contains(gmailURL, "://") {
+		gmailURL = "https://" + gmailURL
+	}
+	parsedURL, err := url.Parse(gmailURL)
+	if err != nil || !strings.HasSuffix(parsedURL.Hostname(), "mail.google.com") {
+		return nil, errors.New("Invalid URL. Please provide the URL of a mail opened in Gmail")
+	}
+
+	// The ID is the last segment of the fragment, e.g. #inbox/<id> or #label/<label>/<id>
+	fragment := strings.SplitN(parsedURL.Fragment, "?", 2)[0]
+	segments := strings.Split(strings.Trim(fragment, "/"), "/")
+	id := segments[len(segments)-1]
+
+	if len(segments) < 2 || id == "" {
+		return nil, errors.New("Invalid URL. Please open the mail in Gmail and copy the URL")
+	}
+	if gmailAPIIDPattern.MatchString(id) {
+		return &mailReference{ThreadID: strings.ToLower(id)}, nil
+	}
+	if gmailWebTokenPattern.MatchString(id) {
+		return decodeGmailWebToken(id)
+	}
+	return nil, errors.New("Invalid URL. Please open the mail in Gmail and copy the URL")
+}
+
+// decodeGmailWebToken decodes the token used by newer Gmail web URLs into the Gmail API ID it stands for.
+// The token is the base64 encoding of e.g. `thread-f:<decimal thread ID>`, written in a base 40 alphabet.
+func decodeGmailWebToken(token string) (*mailReference, error) {
+	invalidTokenErr := errors.New("Unsupported Gmail URL. Please use the Message-ID from 'Show Original' instead")
+
+	number := new(big.Int)
+	base := big.NewInt(int64(len(gmailWebTokenCharset)))
+	for _, char := range token {
+		number.Mul(number, base)
+		number.Add(number, big.NewInt(int64(strings.IndexRune(gmailWebTokenCharset, char))))
+	}
+
+	encoded := ""
+	base64Base := big.NewInt(int64(len(base64Charset)))
+	digit := new(big.Int)
+	for number.Sign() > 0 {
+		number.DivMod(number, base64Base, digit)
+		encoded = string(base64Charset[digit.Int64()]) + encoded
+	}
+
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, invalidTokenErr
+	}
+
+	// Tokens of threads decode to `thread-f:<id>` or `f:<id>`, and of messages to `msg-f:<id>`
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, invalidTokenErr
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, invalidTokenErr
+	}
+	hexID := strconv.FormatUint(id, 16)
+
+	switch parts[0] {
+	case "f", "thread-f":
+		return &mailReference{ThreadID: hexID}, nil
+	case "msg-f":
+		return &mailReference{MessageID: hexID}, nil
+	default:
+		return nil, invalidTokenErr
+	}
+}
+
+// isNotFound checks if the error was returned by the Gmail API because the requested resource does not exist
+func isNotFound(err error) bool {
+	apiErr, ok := errors.Cause(err).(*googleapi.Error)
+	return ok && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusBadRequest)
+}
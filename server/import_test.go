@@ -0,0 +1,207 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeGmailWebToken(t *testing.T) {
+	for name, test := range map[string]struct {
+		token    string
+		expected *mailReference
+	}{
+		"thread token": {
+			// thread-f:1706451371812151000
+			token:    "NHgvNZFVxQXXXjLLhkPgLwdxJMrwDnQkCbLCDhmgNlq",
+			expected: &mailReference{ThreadID: "17ae887b43575ed8"},
+		},
+		"short thread token": {
+			// f:1600000000000000000
+			token:    "FMfcgxvwxxQdHgjZQfXFJdmdBJGJFvKg",
+			expected: &mailReference{ThreadID: "16345785d8a00000"},
+		},
+		"message token": {
+			// msg-f:1706451371812151000
+			token:    "CXKnWZngLGgsFgtFpShdxHpbxcPJxxtjwqLJjlq",
+			expected: &mailReference{MessageID: "17ae887b43575ed8"},
+		},
+		"unknown kind": {
+			// thread-a:r123
+			token: "CRkRwgGzRHGkfTlrTTDGV",
+		},
+		"non-numeric ID": {
+			// thread-f:abc
+			token: "bxqQqcwsbGxVNLqhzP",
+		},
+		"no separator": {
+			// nocolon
+			token: "CKWNWLjsxmvB",
+		},
+		"zero": {
+			token: "BBBBBBBBBBBBBBBBBBBBB",
+		},
+		"invalid base64": {
+			token: "B",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reference, err := decodeGmailWebToken(test.token)
+			if test.expected == nil {
+				assert.Error(t, err)
+				assert.Nil(t, reference)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, reference)
+		})
+	}
+}
+
+func TestParseGmailURL(t *testing.T) {
+	for name, test := range map[string]struct {
+		url      string
+		expected *mailReference
+	}{
+		"inbox URL with API ID": {
+			url:      "https://mail.google.com/mail/u/0/#inbox/17ae887b43575ed8",
+			expected: &mailReference{ThreadID: "17ae887b43575ed8"},
+		},
+		"label URL with uppercase API ID": {
+			url:      "https://mail.google.com/mail/u/1/#label/Work%2FProjects/17AE887B43575ED8",
+			expected: &mailReference{ThreadID: "17ae887b43575ed8"},
+		},
+		"URL without scheme": {
+			url:      "mail.google.com/mail/u/0/#sent/17ae887b43575ed8",
+			expected: &mailReference{ThreadID: "17ae887b43575ed8"},
+		},
+		"URL with parameters in the fragment": {
+			url:      "https://mail.google.com/mail/u/0/#inbox/17ae887b43575ed8?compose=new",
+			expected: &mailReference{ThreadID: "17ae887b43575ed8"},
+		},
+		"URL with thread token": {
+			url:      "https://mail.google.com/mail/u/0/#inbox/FMfcgxvwxxQdHgjZQfXFJdmdBJGJFvKg",
+			expected: &mailReference{ThreadID: "16345785d8a00000"},
+		},
+		"URL with message token": {
+			url:      "https://mail.google.com/mail/u/0/#all/CXKnWZngLGgsFgtFpShdxHpbxcPJxxtjwqLJjlq",
+			expected: &mailReference{MessageID: "17ae887b43575ed8"},
+		},
+		"other host": {
+			url: "https://mail.example.com/mail/u/0/#inbox/17ae887b43575ed8",
+		},
+		"host ending like Gmail": {
+			url: "https://evilmail.google.com.example.com/#inbox/17ae887b43575ed8",
+		},
+		"no fragment": {
+			url: "https://mail.google.com/mail/u/0/",
+		},
+		"folder without mail": {
+			url: "https://mail.google.com/mail/u/0/#inbox",
+		},
+		"trailing slash only": {
+			url: "https://mail.google.com/mail/u/0/#inbox/",
+		},
+		"search URL": {
+			url: "https://mail.google.com/mail/u/0/#search/from%3Asomeone",
+		},
+		"short token": {
+			url: "https://mail.google.com/mail/u/0/#inbox/FMfcgxvwxx",
+		},
+		"token with invalid characters": {
+			url: "https://mail.google.com/mail/u/0/#inbox/FMfcgxvwxxQdHgjZQfXFJdmdBJGJFvKa",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reference, err := parseGmailURL(test.url)
+			if test.expected == nil {
+				assert.Error(t, err)
+				assert.Nil(t, reference)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, reference)
+		})
+	}
+}
+
+func TestStoreImportedMessage(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("KVSetWithOptions", "channel17ae887b43575ed8imported", []byte(`{"RootID":"root","PostIDs":["post"]}`), model.PluginKVSetOptions{
+		ExpireInSeconds: int64(importedMessageExpiry / time.Second),
+	}).Return(true, nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	require.NoError(t, p.storeImportedMessage("channel", "17ae887b43575ed8", &importedMessage{RootID: "root", PostIDs: []string{"post"}}))
+	api.AssertExpectations(t)
+}
+
+func TestGetImportedMessage(t *testing.T) {
+	notFound := model.NewAppError("GetPost", "", nil, "", 404)
+	for name, test := range map[string]struct {
+		stored          string
+		deletedPostIDs  []string
+		expected        *importedMessage
+		expectedDeleted bool
+	}{
+		"not imported": {},
+		"imported as the root of a thread": {
+			stored:   `{"RootID":"post","PostIDs":["post","continuation"]}`,
+			expected: &importedMessage{RootID: "post", PostIDs: []string{"post", "continuation"}},
+		},
+		"imported as a reply": {
+			stored:   `{"RootID":"root","PostIDs":["post"]}`,
+			expected: &importedMessage{RootID: "root", PostIDs: []string{"post"}},
+		},
+		"post deleted": {
+			stored:          `{"RootID":"root","PostIDs":["post"]}`,
+			deletedPostIDs:  []string{"post"},
+			expectedDeleted: true,
+		},
+		"root deleted": {
+			stored:          `{"RootID":"root","PostIDs":["post"]}`,
+			deletedPostIDs:  []string{"root"},
+			expectedDeleted: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			var stored []byte
+			if test.stored != "" {
+				stored = []byte(test.stored)
+			}
+			api.On("KVGet", "channel17ae887b43575ed8imported").Return(stored, nil)
+			deleted := map[string]bool{}
+			for _, postID := range test.deletedPostIDs {
+				deleted[postID] = true
+			}
+			api.On("GetPost", mock.Anything).Return(func(postID string) *model.Post {
+				if deleted[postID] {
+					return nil
+				}
+				return &model.Post{Id: postID}
+			}, func(postID string) *model.AppError {
+				if deleted[postID] {
+					return notFound
+				}
+				return nil
+			}).Maybe()
+			if test.expectedDeleted {
+				api.On("KVDelete", "channel17ae887b43575ed8imported").Return(nil)
+			}
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			imported, err := p.getImportedMessage("channel", "17ae887b43575ed8")
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, imported)
+			api.AssertExpectations(t)
+		})
+	}
+}
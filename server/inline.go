@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// uploadEmbeddedFiles uploads the files embedded in the mail, such as the inline images of HTML mails, to the channel,
+// pointing the cid: references to them in the body of the mail to the uploaded files.
+// The IDs of the uploaded files are returned, as the files are accessible to the members of the channel only once posted,
+// along with the files not uploaded as per the configuration, which can be fetched on demand like the skipped attachments.
+func (p *Plugin) uploadEmbeddedFiles(parsed *parsedMessage, channelID string, fileNameCounts map[string]int) ([]string, []*skippedAttachment) {
+	siteURL := strings.TrimSuffix(*p.API.GetConfig().ServiceSettings.SiteURL, "/")
+
+	fileIDs := []string{}
+	skippedFiles := []*skippedAttachment{}
+	for index, embeddedFile := range parsed.EmbeddedFiles {
+		fileName := embeddedFile.FileName
+		if fileName == "" {
+			fileName = getEmbeddedFileName(embeddedFile.ContentType, index)
+		}
+		fileInfo, skipped := p.uploadMailFile(embeddedFile, fileName, channelID, fileNameCounts)
+		if skipped != nil {
+			skippedFiles = append(skippedFiles, skipped)
+			continue
+		}
+		fileIDs = append(fileIDs, fileInfo.Id)
+
+		if embeddedFile.CID == "" {
+			continue
+		}
+		fileURL := getFileURL(siteURL, fileInfo)
+		for _, cid := range []string{embeddedFile.CID, url.PathEscape(embeddedFile.CID)} {
+			parsed.Body = strings.Replace(parsed.Body, "cid:"+cid, fileURL, -1)
+			parsed.HTMLBody = strings.Replace(parsed.HTMLBody, "cid:"+cid, fileURL, -1)
+		}
+	}
+	return fileIDs, skippedFiles
+}
+
+// getEmbeddedFileName returns the name given to the embedded file in its content type,
+// or a name based on its position in the mail with the extension of its type
+func getEmbeddedFileName(contentType string, index int) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Sprintf("inline-%d", index+1)
+	}
+	if name := strings.TrimSpace(params["name"]); name != "" {
+		return name
+	}
+
+	fileName := fmt.Sprintf("inline-%d", index+1)
+	if mediaType == "image/jpeg" {
+		// The extensions of JPEG images are listed with the less known ones first
+		return fileName + ".jpg"
+	}
+	if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+		fileName += extensions[0]
+	}
+	return fileName
+}
+
+// getFileURL returns the URL of the uploaded file, using its preview for images
+func getFileURL(siteURL string, fileInfo *model.FileInfo) string {
+	fileURL := siteURL + "/api/v4/files/" + fileInfo.Id
+	if fileInfo.IsImage() && fileInfo.HasPreviewImage {
+		fileURL += "/preview"
+	}
+	return fileURL
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"google.golang.org/api/gmail/v1"
+)
+
+// getLabels returns the system and user labels of the Gmail account of the user
+func (p *Plugin) getLabels(userID string, gmailID string) ([]*gmail.Label, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	labelsResponse, err := gmailService.Users.Labels.List(gmailID).Do()
+	if err != nil {
+		return nil, err
+	}
+	return labelsResponse.Labels, nil
+}
+
+// resolveLabels finds the labels identified by the given label IDs or names (compared ignoring case).
+// It also returns the label IDs or names for which no label is found.
+func resolveLabels(labels []*gmail.Label, labelIDsOrNames []string) ([]*gmail.Label, []string) {
+	resolvedLabels := []*gmail.Label{}
+	unknown := []string{}
+
+	for _, labelIDOrName := range labelIDsOrNames {
+		labelIDOrName = strings.TrimSpace(labelIDOrName)
+		if labelIDOrName == "" {
+			continue
+		}
+
+		var resolvedLabel *gmail.Label
+		for _, label := range labels {
+			if label.Id == labelIDOrName {
+				resolvedLabel = label
+				break
+			}
+			if resolvedLabel == nil && (strings.EqualFold(label.Id, labelIDOrName) || strings.EqualFold(label.Name, labelIDOrName)) {
+				resolvedLabel = label
+			}
+		}
+
+		if resolvedLabel == nil {
+			unknown = append(unknown, labelIDOrName)
+			continue
+		}
+		resolvedLabels = append(resolvedLabels, resolvedLabel)
+	}
+	return resolvedLabels, unknown
+}
+
+// getLabelIDs returns the IDs of the labels, without duplicates
+func getLabelIDs(labels []*gmail.Label) []string {
+	labelIDs := []string{}
+	addedLabelIDs := map[string]bool{}
+	for _, label := range labels {
+		if !addedLabelIDs[label.Id] {
+			addedLabelIDs[label.Id] = true
+			labelIDs = append(labelIDs, label.Id)
+		}
+	}
+	return labelIDs
+}
+
+// getLabelDisplayNames returns the names of the labels with the given IDs, falling back to the ID for unknown labels
+func getLabelDisplayNames(labels []*gmail.Label, labelIDs []string) []string {
+	names := []string{}
+	for _, labelID := range labelIDs {
+		name := labelID
+		for _, label := range labels {
+			if label.Id == labelID {
+				name = formatLabelName(label)
+				break
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// formatLabelName formats the label as its name, followed by its ID for user labels
+func formatLabelName(label *gmail.Label) string {
+	if label.Name == label.Id {
+		return label.Name
+	}
+	return label.Name + " (" + label.Id + ")"
+}
+
+// formatLabelList lists the labels as markdown, system labels first
+func formatLabelList(labels []*gmail.Label) string {
+	sortedLabels := make([]*gmail.Label, len(labels))
+	copy(sortedLabels, labels)
+	sortLabels(sortedLabels)
+
+	list := ""
+	for _, label := range sortedLabels {
+		list += "* " + formatLabelName(label) + "\n"
+	}
+	return list
+}
+
+// labelCountsConcurrency is the maximum number of labels fetched at once for their message counts
+const labelCountsConcurrency = 5
+
+// getLabelsWithCounts returns the labels of the Gmail account of the user along with their message counts.
+// The IDs of the labels whose counts could be fetched are returned as well.
+func (p *Plugin) getLabelsWithCounts(userID string, gmailID string) ([]*gmail.Label, map[string]bool, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labels, err := p.getLabels(userID, gmailID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Message counts are only returned when a label is fetched individually
+	labelsWithCounts, counted := fetchLabelCounts(labels, func(labelID string) (*gmail.Label, error) {
+		return gmailService.Users.Labels.Get(gmailID, labelID).Do()
+	})
+	if len(counted) < len(labels) {
+		p.API.LogWarn(fmt.Sprintf("Could not fetch the message counts of %d labels", len(labels)-len(counted)), "userID", userID)
+	}
+	return labelsWithCounts, counted, nil
+}
+
+// fetchLabelCounts fetches the labels with their message counts using getLabel, a few at a time, keeping the order of the labels.
+// The labels that could not be fetched are kept without their counts, the IDs of the others being returned.
+func fetchLabelCounts(labels []*gmail.Label, getLabel func(labelID string) (*gmail.Label, error)) ([]*gmail.Label, map[string]bool) {
+	labelsWithCounts := make([]*gmail.Label, len(labels))
+	fetched := make([]bool, len(labels))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, labelCountsConcurrency)
+	for index, label := range labels {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(index int, label *gmail.Label) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			labelsWithCounts[index] = label
+			if labelWithCounts, err := getLabel(label.Id); err == nil {
+				labelsWithCounts[index] = labelWithCounts
+				fetched[index] = true
+			}
+		}(index, label)
+	}
+	wg.Wait()
+
+	counted := map[string]bool{}
+	for index, label := range labelsWithCounts {
+		if fetched[index] {
+			counted[label.Id] = true
+		}
+	}
+	return labelsWithCounts, counted
+}
+
+// getLabelsPost creates the post listing the labels of the user, with buttons to subscribe or unsubscribe to each label
+func (p *Plugin) getLabelsPost(userID string, channelID string) (*model.Post, error) {
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, counted, err := p.getLabelsWithCounts(userID, gmailID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions, err := p.getSubscriptionsOfUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := map[string]bool{}
+	for _, labelID := range subscriptions {
+		subscribed[labelID] = true
+	}
+
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	actionSecret := p.getActionSecret()
+
+	sortLabels(labels)
+	attachments := []*model.SlackAttachment{}
+	for _, label := range labels {
+		action := ActionSubscribeLabel
+		buttonName := "Subscribe"
+		status := ""
+		if subscribed[label.Id] {
+			action = ActionUnsubscribeLabel
+			buttonName = "Unsubscribe"
+			status = " :white_check_mark: Subscribed"
+		}
+
+		counts := "Message counts are unavailable."
+		if counted[label.Id] {
+			counts = fmt.Sprintf("Unread: %d, Total: %d", label.MessagesUnread, label.MessagesTotal)
+		}
+
+		attachments = append(attachments, &model.SlackAttachment{
+			Title: formatLabelName(label) + status,
+			Text:  counts,
+			Actions: []*model.PostAction{{
+				Type: model.POST_ACTION_TYPE_BUTTON,
+				Name: buttonName,
+				Integration: &model.PostActionIntegration{
+					URL: fmt.Sprintf("%s/plugins/%s/command/labels", siteURL, manifest.Id),
+					Context: map[string]interface{}{
+						"action":       action,
+						"actionSecret": actionSecret,
+						"labelID":      label.Id,
+					},
+				},
+			}},
+		})
+	}
+
+	return &model.Post{
+		UserId:    p.gmailBotID,
+		ChannelId: channelID,
+		Message:   fmt.Sprintf("###### Labels in %s\n:white_check_mark: marks the labels you are subscribed to.", gmailID),
+		Props: map[string]interface{}{
+			"attachments": attachments,
+		},
+	}, nil
+}
+
+// sortLabels sorts the labels by their name, system labels first
+func sortLabels(labels []*gmail.Label) {
+	sort.SliceStable(labels, func(i, j int) bool {
+		if labels[i].Type != labels[j].Type {
+			return labels[i].Type == "system"
+		}
+		return strings.ToLower(labels[i].Name) < strings.ToLower(labels[j].Name)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestFetchLabelCounts(t *testing.T) {
+	for name, test := range map[string]struct {
+		labelCount      int
+		failedLabelIDs  map[string]bool
+		expectedCounted int
+	}{
+		"no labels": {},
+		"all labels fetched": {
+			labelCount:      12,
+			expectedCounted: 12,
+		},
+		"some labels failed": {
+			labelCount:      12,
+			failedLabelIDs:  map[string]bool{"Label_3": true, "Label_7": true},
+			expectedCounted: 10,
+		},
+		"all labels failed": {
+			labelCount:     3,
+			failedLabelIDs: map[string]bool{"Label_0": true, "Label_1": true, "Label_2": true},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			labels := []*gmail.Label{}
+			for i := 0; i < test.labelCount; i++ {
+				labels = append(labels, &gmail.Label{Id: fmt.Sprintf("Label_%d", i), Name: fmt.Sprintf("Label %d", i)})
+			}
+
+			var lock sync.Mutex
+			running, maxRunning := 0, 0
+			getLabel := func(labelID string) (*gmail.Label, error) {
+				lock.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				lock.Unlock()
+				time.Sleep(time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+
+				if test.failedLabelIDs[labelID] {
+					return nil, errors.New("rate limited")
+				}
+				return &gmail.Label{Id: labelID, MessagesTotal: 10, MessagesUnread: 2}, nil
+			}
+
+			labelsWithCounts, counted := fetchLabelCounts(labels, getLabel)
+			assert.LessOrEqual(t, maxRunning, labelCountsConcurrency)
+			assert.Len(t, counted, test.expectedCounted)
+			if assert.Len(t, labelsWithCounts, len(labels)) {
+				for i, label := range labelsWithCounts {
+					assert.Equal(t, labels[i].Id, label.Id)
+					if test.failedLabelIDs[label.Id] {
+						assert.Same(t, labels[i], label)
+						assert.False(t, counted[label.Id])
+					} else {
+						assert.Equal(t, int64(10), label.MessagesTotal)
+						assert.True(t, counted[label.Id])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// longMailSplit and longMailAttach are the ways of posting the mails too long to fit in a post
+	longMailSplit  = "split"
+	longMailAttach = "attach"
+
+	// mailFileNameMaxLength is the maximum length of the name of the file the full mail is attached as, without its extension
+	mailFileNameMaxLength = 100
+
+	longMailContinuedNote = "\n\n_(The mail is continued in the replies)_"
+	longMailAttachedNote  = "\n\n_(The mail is too long to be shown completely, see the attached file for the full mail)_"
+)
+
+// fitMailBody shortens the body of the mail post to fit the limit on the size of the props of a post, returning the rest
+// of the body to be posted as replies. When long mails are to be attached, the full mail is instead uploaded as a file
+// attached to the post, and nothing is returned.
+func (p *Plugin) fitMailBody(post *model.Post, parsed *parsedMessage, channelID string) []string {
+	attachments := post.Attachments()
+	if len(attachments) == 0 {
+		return nil
+	}
+	attachment := attachments[0]
+
+	propsFit := func(text string) bool {
+		attachment.Text = text
+		return utf8.RuneCountInString(model.StringInterfaceToJson(post.GetProps())) <= model.POST_PROPS_MAX_USER_RUNES
+	}
+
+	body := strings.Replace(parsed.Body, "\r\n", "\n", -1)
+	if propsFit(body) {
+		return nil
+	}
+
+	if p.getConfiguration().LongMailHandling == longMailAttach {
+		fileInfo, err := p.uploadMailBody(parsed, channelID)
+		if err == nil {
+			preview, _ := splitMailBody(body, func(preview string) bool {
+				return propsFit(preview + longMailAttachedNote)
+			})
+			attachment.Text = preview + longMailAttachedNote
+			post.FileIds = append(post.FileIds, fileInfo.Id)
+			return nil
+		}
+		p.API.LogError("Could not attach the full mail, posting it in replies", "err", err.Error())
+	}
+
+	preview, rest := splitMailBody(body, func(preview string) bool {
+		return propsFit(preview + longMailContinuedNote)
+	})
+	attachment.Text = preview + longMailContinuedNote
+
+	continuations := []string{}
+	for rest != "" {
+		var continuation string
+		continuation, rest = splitMailBody(rest, func(continuation string) bool {
+			return utf8.RuneCountInString(continuation) <= model.POST_MESSAGE_MAX_RUNES_V2
+		})
+		continuations = append(continuations, continuation)
+	}
+	return continuations
+}
+
+// splitMailBody splits the body after its longest beginning that fits, preferring to split between paragraphs,
+// then between lines, then between words. The beginning is empty if nothing fits.
+func splitMailBody(body string, fits func(string) bool) (string, string) {
+	if fits(body) {
+		return body, ""
+	}
+
+	for _, separator := range []string{"\n\n", "\n", " "} {
+		cuts := []int{}
+		for offset := 0; ; {
+			index := strings.Index(body[offset:], separator)
+			if index < 0 {
+				break
+			}
+			cuts = append(cuts, offset+index)
+			offset += index + len(separator)
+		}
+
+		// The beginnings that fit are the ones shorter than the first beginning that does not fit
+		count := sort.Search(len(cuts), func(i int) bool {
+			return !fits(body[:cuts[i]])
+		})
+		for i := count - 1; i >= 0; i-- {
+			if strings.TrimSpace(body[:cuts[i]]) != "" {
+				return strings.TrimRight(body[:cuts[i]], " \n"), strings.TrimLeft(body[cuts[i]+len(separator):], "\n")
+			}
+		}
+	}
+
+	cuts := []int{}
+	for offset := range body {
+		if offset > 0 {
+			cuts = append(cuts, offset)
+		}
+	}
+	count := sort.Search(len(cuts), func(i int) bool {
+		return !fits(body[:cuts[i]])
+	})
+	if count == 0 {
+		return "", body
+	}
+	return body[:cuts[count-1]], body[cuts[count-1]:]
+}
+
+// uploadMailBody uploads the full body of the mail to the channel, as sanitized HTML for HTML mails and as markdown otherwise
+func (p *Plugin) uploadMailBody(parsed *parsedMessage, channelID string) (*model.FileInfo, error) {
+	fileName := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(parsed.Subject))
+	if fileName == "" {
+		fileName = "mail"
+	}
+	if utf8.RuneCountInString(fileName) > mailFileNameMaxLength {
+		fileName = string([]rune(fileName)[:mailFileNameMaxLength])
+	}
+
+	data := parsed.Body
+	fileName += ".md"
+	if parsed.HTMLBody != "" {
+		// The HTML of the mail is untrusted, so only its sanitized version is uploaded
+		sanitizedHTML, err := sanitizeMailHTMLFile(parsed.HTMLBody, p.getConfiguration().ShowLinkTargets)
+		if err != nil {
+			p.API.LogError("Could not sanitize the HTML of the mail, uploading it as markdown", "err", err.Error())
+		} else {
+			data = sanitizedHTML
+			fileName = strings.TrimSuffix(fileName, ".md") + ".html"
+		}
+	}
+
+	fileInfo, appErr := p.API.UploadFile([]byte(data), channelID, fileName)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return fileInfo, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/htmlindex"
+	"google.golang.org/api/gmail/v1"
+)
+
+// mimePartMaxDepth is the maximum depth of the multiparts nested in a mail
+const mimePartMaxDepth = 20
+
+// mailWordDecoder decodes the RFC 2047 encoded words in the headers of mails, in any of the charsets known to browsers
+var mailWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charsetName string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(charsetName)
+		if err != nil {
+			return nil, err
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	},
+}
+
+// mailFile is a file in the mail, either attached to it or embedded in its body
+type mailFile struct {
+	FileName    string
+	ContentType string
+	// CID is the Content-ID the body of the mail refers to the embedded file by
+	CID string
+	// PartID is the position of the part of the file in the mail as numbered by Gmail, eg. 1.0 for the first part in the second part
+	PartID string
+	Data   []byte
+}
+
+// parseMailMessage extracts the headers, the bodies and the files of the raw mail, walking all its nested multiparts
+func parseMailMessage(rawMessage string) (*parsedMessage, error) {
+	message, err := mail.ReadMessage(strings.NewReader(rawMessage))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := newParsedMessage(message.Header)
+	if err = parsed.addMIMEPart(textproto.MIMEHeader(message.Header), message.Body, "", 0); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// newParsedMessage creates the parsed message with the details in the headers of the mail, ignoring the invalid ones
+func newParsedMessage(header mail.Header) *parsedMessage {
+	date, err := mail.ParseDate(header.Get("Date"))
+	if err != nil {
+		// The time Gmail received the mail is shown instead
+		date = time.Time{}
+	}
+
+	mailHeader := mailHeader{
+		From:    parseHeaderAddresses(header, "From"),
+		ReplyTo: parseHeaderAddresses(header, "Reply-To"),
+		To:      parseHeaderAddresses(header, "To"),
+		Cc:      parseHeaderAddresses(header, "Cc"),
+		Bcc:     parseHeaderAddresses(header, "Bcc"),
+	}
+	if sender := parseHeaderAddresses(header, "Sender"); len(sender) > 0 {
+		mailHeader.Sender = sender[0]
+	}
+
+	return &parsedMessage{
+		Subject:    decodeHeaderValue(header.Get("Subject")),
+		Date:       date,
+		DateHeader: header.Get("Date"),
+		Header:     mailHeader,
+		MessageID:  strings.Trim(header.Get("Message-ID"), "<> "),
+		RawHeader:  header,
+	}
+}
+
+// addMIMEPart adds the content of the part of the raw mail, and of the parts nested in it, to the parsed message
+func (parsed *parsedMessage) addMIMEPart(header textproto.MIMEHeader, body io.Reader, partID string, depth int) error {
+	if depth > mimePartMaxDepth {
+		return errors.New("the parts of the mail are nested too deeply")
+	}
+
+	mediaType, params := getMediaType(header)
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			return errors.New("no boundary found for the " + mediaType + " part")
+		}
+		// The parts encoded as quoted-printable are decoded by the reader
+		reader := multipart.NewReader(body, params["boundary"])
+		for index := 0; ; index++ {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = parsed.addMIMEPart(part.Header, part, getChildPartID(partID, index), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := decodeTransferEncoding(body, header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return err
+	}
+	parsed.addPart(header, data, partID)
+	return nil
+}
+
+// getChildPartID returns the ID of the part at the index in the multipart with the given ID
+func getChildPartID(partID string, index int) string {
+	if partID == "" {
+		return strconv.Itoa(index)
+	}
+	return partID + "." + strconv.Itoa(index)
+}
+
+// addPart adds the decoded content of the part of the mail to the parsed message, as its body, an attachment,
+// or a file embedded in its body. Files without a name are embedded to be named after their type.
+func (parsed *parsedMessage) addPart(header textproto.MIMEHeader, data []byte, partID string) {
+	mediaType, params := getMediaType(header)
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	fileName := dispositionParams["filename"]
+	if fileName == "" {
+		fileName = params["name"]
+	}
+	fileName = decodeHeaderValue(fileName)
+	contentID := strings.Trim(header.Get("Content-ID"), "<> ")
+	inline := disposition != "attachment"
+
+	switch {
+	case contentID != "" && inline:
+		parsed.EmbeddedFiles = append(parsed.EmbeddedFiles, mailFile{FileName: fileName, ContentType: header.Get("Content-Type"), CID: contentID, PartID: partID, Data: data})
+	case fileName != "":
+		parsed.Attachments = append(parsed.Attachments, mailFile{FileName: fileName, ContentType: mediaType, PartID: partID, Data: data})
+	case mediaType == "text/html" && inline:
+		parsed.HTMLBody += decodeBodyText(data, params["charset"], mediaType)
+	case mediaType == "text/plain" && inline:
+		// The text split around inline files is joined
+		if parsed.Body != "" {
+			parsed.Body += "\n\n"
+		}
+		parsed.Body += decodeBodyText(data, params["charset"], mediaType)
+	default:
+		parsed.EmbeddedFiles = append(parsed.EmbeddedFiles, mailFile{ContentType: header.Get("Content-Type"), PartID: partID, Data: data})
+	}
+}
+
+// getMediaType returns the media type of the part and its parameters, text/plain if missing or invalid
+func getMediaType(header textproto.MIMEHeader) (string, map[string]string) {
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		return "text/plain", map[string]string{}
+	}
+	if params == nil {
+		params = map[string]string{}
+	}
+	return mediaType, params
+}
+
+// decodeTransferEncoding decodes the content of the part, ignoring the whitespace and the missing padding in base64
+func decodeTransferEncoding(body io.Reader, encoding string) ([]byte, error) {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		data = bytes.Join(bytes.Fields(data), nil)
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(string(data), "="))
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(data)))
+	default:
+		return data, nil
+	}
+}
+
+// decodeText converts the text in the charset to UTF-8. Text already in UTF-8 with non-ASCII characters is kept as is,
+// as it is often labeled with another charset. The charset of text with an unknown charset is detected from
+// the meta tags of HTML, defaulting to Windows-1252.
+func decodeText(data []byte, charsetName string, mediaType string) string {
+	if utf8.Valid(data) && !isASCII(data) {
+		return string(data)
+	}
+
+	encoding, err := htmlindex.Get(strings.TrimSpace(charsetName))
+	if err != nil {
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		encoding, _, _ = charset.DetermineEncoding(data, mediaType)
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// decodeBodyText decodes the text of the body of the mail to UTF-8, with LF line endings and without the final line break
+func decodeBodyText(data []byte, charsetName string, mediaType string) string {
+	text := strings.Replace(decodeText(data, charsetName, mediaType), "\r\n", "\n", -1)
+	return strings.TrimSuffix(text, "\n")
+}
+
+// isASCII checks if the data has only ASCII characters
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeHeaderValue decodes the RFC 2047 encoded words in the value of the header,
+// and the 8-bit text sent without encoding, keeping the value as is if it cannot be decoded
+func decodeHeaderValue(value string) string {
+	decoded, err := mailWordDecoder.DecodeHeader(value)
+	if err != nil {
+		decoded = value
+	}
+	if !utf8.ValidString(decoded) {
+		decoded = decodeText([]byte(decoded), "", "text/plain")
+	}
+	return strings.TrimSpace(decoded)
+}
+
+// parseHeaderAddresses parses the addresses in the header, keeping the valid ones when the list is invalid
+func parseHeaderAddresses(header mail.Header, name string) []*mail.Address {
+	value := header.Get(name)
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	parser := &mail.AddressParser{WordDecoder: mailWordDecoder}
+	if addresses, err := parser.ParseList(value); err == nil {
+		return addresses
+	}
+
+	addresses := []*mail.Address{}
+	for _, item := range strings.Split(value, ",") {
+		if address, err := parser.Parse(item); err == nil {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// getPayloadMessage extracts the details of the mail from the parts of the Gmail message in its full format,
+// used when the raw message cannot be parsed. The bodies of the parts are already decoded by Gmail.
+func (p *Plugin) getPayloadMessage(userID string, gmailID string, messageID string) (*parsedMessage, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := gmailService.Users.Messages.Get(gmailID, messageID).Format("full").Do()
+	if err != nil {
+		return nil, err
+	}
+	if message.Payload == nil {
+		return nil, errors.New("no payload found in the message")
+	}
+
+	parsed := newParsedMessage(getPayloadHeader(message.Payload.Headers))
+	if err = p.addPayloadPart(gmailService, gmailID, messageID, parsed, message.Payload, 0); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// addPayloadPart adds the content of the part of the Gmail message, and of the parts nested in it, to the parsed message.
+// The content of large parts, such as attachments, is fetched separately.
+func (p *Plugin) addPayloadPart(gmailService *gmail.Service, gmailID string, messageID string, parsed *parsedMessage, part *gmail.MessagePart, depth int) error {
+	if depth > mimePartMaxDepth {
+		return errors.New("the parts of the mail are nested too deeply")
+	}
+	if len(part.Parts) > 0 || strings.HasPrefix(part.MimeType, "multipart/") {
+		for _, childPart := range part.Parts {
+			if err := p.addPayloadPart(gmailService, gmailID, messageID, parsed, childPart, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if part.Body == nil {
+		return nil
+	}
+
+	data := part.Body.Data
+	if part.Body.AttachmentId != "" {
+		attachmentBody, err := gmailService.Users.Messages.Attachments.Get(gmailID, messageID, part.Body.AttachmentId).Do()
+		if err != nil {
+			return err
+		}
+		data = attachmentBody.Data
+	}
+
+	decoded, err := p.decodeBase64URL(data)
+	if err != nil {
+		return err
+	}
+	parsed.addPart(textproto.MIMEHeader(getPayloadHeader(part.Headers)), []byte(decoded), part.PartId)
+	return nil
+}
+
+// getPayloadHeader returns the headers of the part of the Gmail message
+func getPayloadHeader(headers []*gmail.MessagePartHeader) mail.Header {
+	header := mail.Header{}
+	for _, partHeader := range headers {
+		key := textproto.CanonicalMIMEHeaderKey(partHeader.Name)
+		header[key] = append(header[key], partHeader.Value)
+	}
+	return header
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/mail"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestNewParsedMessageDate(t *testing.T) {
+	for name, test := range map[string]struct {
+		date         string
+		expectedDate time.Time
+	}{
+		"valid date": {
+			date:         "Tue, 15 Sep 2020 10:30:00 +0530",
+			expectedDate: time.Date(2020, time.September, 15, 5, 0, 0, 0, time.UTC),
+		},
+		"date with comment": {
+			date:         "Tue, 15 Sep 2020 10:30:00 +0000 (UTC)",
+			expectedDate: time.Date(2020, time.September, 15, 10, 30, 0, 0, time.UTC),
+		},
+		"invalid date": {
+			date: "yesterday",
+		},
+		"no date": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rawMessage := "From: someone@example.com\r\nSubject: Hello\r\n"
+			if test.date != "" {
+				rawMessage += "Date: " + test.date + "\r\n"
+			}
+			rawMessage += "\r\nHello"
+
+			parsed, err := parseMailMessage(rawMessage)
+			require.NoError(t, err)
+			assert.Equal(t, test.date, parsed.DateHeader)
+			assert.True(t, test.expectedDate.Equal(parsed.Date), "expected %s, got %s", test.expectedDate, parsed.Date)
+
+			// The headers of the Gmail payload, used when the raw mail cannot be parsed
+			headers := []*gmail.MessagePartHeader{{Name: "From", Value: "someone@example.com"}}
+			if test.date != "" {
+				headers = append(headers, &gmail.MessagePartHeader{Name: "date", Value: test.date})
+			}
+			parsed = newParsedMessage(getPayloadHeader(headers))
+			assert.Equal(t, test.date, parsed.DateHeader)
+			assert.True(t, test.expectedDate.Equal(parsed.Date), "expected %s, got %s", test.expectedDate, parsed.Date)
+		})
+	}
+}
+
+func TestGetChildPartID(t *testing.T) {
+	for name, test := range map[string]struct {
+		partID   string
+		index    int
+		expected string
+	}{
+		"part of the mail":        {partID: "", index: 0, expected: "0"},
+		"part of a nested part":   {partID: "1", index: 2, expected: "1.2"},
+		"deeply nested part":      {partID: "1.0.3", index: 1, expected: "1.0.3.1"},
+		"part after the first 10": {partID: "0", index: 10, expected: "0.10"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, getChildPartID(test.partID, test.index))
+		})
+	}
+}
+
+func TestParseMailMessage(t *testing.T) {
+	chartPNG, err := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP4//8/AAX+Av6n1gWlAAAAAElFTkSuQmCC")
+	require.NoError(t, err)
+
+	for name, test := range map[string]struct {
+		file                  string
+		expectedSubject       string
+		expectedFrom          []*mail.Address
+		expectedBody          string
+		expectedHTMLBody      string
+		expectedAttachments   []mailFile
+		expectedEmbeddedFiles []mailFile
+		expectedErr           string
+	}{
+		"ISO-8859-1": {
+			file:            "iso-8859-1.eml",
+			expectedSubject: "Café crème",
+			expectedFrom:    []*mail.Address{{Name: "René Dupont", Address: "rene@example.com"}},
+			expectedBody:    "Le café est prêt à côté.",
+		},
+		"Windows-1252 quoted-printable": {
+			file:            "windows-1252-qp.eml",
+			expectedSubject: "“Invoice”",
+			expectedFrom:    []*mail.Address{{Address: "billing@example.com"}},
+			expectedBody:    "“Smart quotes” – the total is €5.\nThis line is long enough to be wrapped by the quoted-printable encoding of the mail body.",
+		},
+		"Shift_JIS": {
+			file:            "shift_jis.eml",
+			expectedSubject: "会議の案内",
+			expectedFrom:    []*mail.Address{{Name: "鈴木一郎", Address: "suzuki@example.jp"}},
+			expectedBody:    "明日の会議は10時からです。",
+		},
+		"ISO-2022-JP": {
+			file:            "iso-2022-jp.eml",
+			expectedSubject: "お知らせ",
+			expectedFrom:    []*mail.Address{{Name: "山田太郎", Address: "yamada@example.jp"}},
+			expectedBody:    "システムのメンテナンスを行います。",
+		},
+		"RFC 2047 subject, sender and filenames": {
+			file:            "rfc2047.eml",
+			expectedSubject: "Grüße aus München",
+			expectedFrom:    []*mail.Address{{Name: "Jürgen Müller", Address: "juergen@example.de"}},
+			expectedBody:    "Die Dateien sind angehängt.",
+			expectedAttachments: []mailFile{
+				{FileName: "Prüfbericht.pdf", ContentType: "application/pdf", PartID: "1", Data: []byte("%PDF-1.4 report")},
+				{FileName: "Übersicht 2020.csv", ContentType: "text/csv", PartID: "2", Data: []byte("month,total\nSeptember,5\n")},
+			},
+		},
+		"nested multiparts": {
+			file:             "nested.eml",
+			expectedSubject:  "Monthly report",
+			expectedFrom:     []*mail.Address{{Name: "Reports", Address: "reports@example.com"}},
+			expectedBody:     "Hello — see the chart.",
+			expectedHTMLBody: `<html><body><p>Hello — see the chart:</p><img src="cid:chart@example.com" alt="Chart"><p>A long line that is wrapped by a soft line break in the quoted-printable encoding.</p></body></html>`,
+			expectedAttachments: []mailFile{
+				{FileName: "report.pdf", ContentType: "application/pdf", PartID: "1", Data: []byte("%PDF-1.4 monthly")},
+			},
+			expectedEmbeddedFiles: []mailFile{
+				{ContentType: "image/png", CID: "chart@example.com", PartID: "0.1", Data: chartPNG},
+				{ContentType: "application/octet-stream", PartID: "2", Data: []byte{0, 1, 2, 3}},
+			},
+		},
+		"unpadded base64": {
+			file:            "unpadded-base64.eml",
+			expectedSubject: "Unpadded",
+			expectedFrom:    []*mail.Address{{Address: "someone@example.com"}},
+			expectedBody:    "The body is encoded without padding.",
+			expectedAttachments: []mailFile{
+				{FileName: "notes.txt", ContentType: "text/plain", PartID: "1", Data: []byte("notes!")},
+			},
+		},
+		"unclosed boundary": {
+			file:        "broken-boundary.eml",
+			expectedErr: "unexpected EOF",
+		},
+		"missing boundary": {
+			file:        "missing-boundary.eml",
+			expectedErr: "no boundary found for the multipart/mixed part",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rawMessage, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+			require.NoError(t, err)
+
+			parsed, err := parseMailMessage(string(rawMessage))
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSubject, parsed.Subject)
+			assert.Equal(t, test.expectedFrom, parsed.Header.From)
+			assert.Equal(t, test.expectedBody, parsed.Body)
+			assert.Equal(t, test.expectedHTMLBody, parsed.HTMLBody)
+			assert.Equal(t, test.expectedAttachments, parsed.Attachments)
+			assert.Equal(t, test.expectedEmbeddedFiles, parsed.EmbeddedFiles)
+		})
+	}
+}
+
+func TestParseHeaderAddressesWithInvalidAddress(t *testing.T) {
+	rawMessage, err := ioutil.ReadFile(filepath.Join("testdata", "nested.eml"))
+	require.NoError(t, err)
+
+	parsed, err := parseMailMessage(string(rawMessage))
+	require.NoError(t, err)
+	assert.Equal(t, []*mail.Address{{Name: "Alice", Address: "alice@example.com"}, {Address: "bob@example.com"}}, parsed.Header.Cc)
+}
+
+func TestDecodeText(t *testing.T) {
+	for name, test := range map[string]struct {
+		data        string
+		charsetName string
+		mediaType   string
+		expected    string
+	}{
+		"ISO-8859-1": {
+			data:        "caf\xe9",
+			charsetName: "ISO-8859-1",
+			expected:    "café",
+		},
+		"ISO-8859-1 used for Windows-1252": {
+			data:        "\x80 5",
+			charsetName: "iso-8859-1",
+			expected:    "€ 5",
+		},
+		"Windows-1252": {
+			data:        "\x93quoted\x94",
+			charsetName: "windows-1252",
+			expected:    "“quoted”",
+		},
+		"Shift_JIS": {
+			data:        "\x89\xef\x8b\x63",
+			charsetName: "Shift_JIS",
+			expected:    "会議",
+		},
+		"ISO-2022-JP": {
+			data:        "\x1b\x24\x42\x24\x2a\x43\x4e\x24\x69\x24\x3b\x1b\x28\x42",
+			charsetName: "ISO-2022-JP",
+			expected:    "お知らせ",
+		},
+		"charset with spaces": {
+			data:        "caf\xe9",
+			charsetName: " latin1 ",
+			expected:    "café",
+		},
+		"UTF-8 labeled with another charset": {
+			data:        "café",
+			charsetName: "ISO-8859-1",
+			expected:    "café",
+		},
+		"ASCII": {
+			data:        "hello",
+			charsetName: "us-ascii",
+			expected:    "hello",
+		},
+		"unknown charset with UTF-8": {
+			data:        "hello",
+			charsetName: "x-unknown",
+			expected:    "hello",
+		},
+		"unknown charset": {
+			data:        "\xe9t\xe9",
+			charsetName: "x-unknown",
+			mediaType:   "text/plain",
+			expected:    "été",
+		},
+		"charset in the meta tag of HTML": {
+			data:      `<html><head><meta charset="shift_jis"></head><body>` + "\x89\xef\x8b\x63" + `</body></html>`,
+			mediaType: "text/html",
+			expected:  `<html><head><meta charset="shift_jis"></head><body>会議</body></html>`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mediaType := test.mediaType
+			if mediaType == "" {
+				mediaType = "text/plain"
+			}
+			assert.Equal(t, test.expected, decodeText([]byte(test.data), test.charsetName, mediaType))
+		})
+	}
+}
+
+func TestDecodeHeaderValue(t *testing.T) {
+	for name, test := range map[string]struct {
+		value    string
+		expected string
+	}{
+		"plain text": {
+			value:    "  Weekly report ",
+			expected: "Weekly report",
+		},
+		"quoted encoding": {
+			value:    "=?ISO-8859-1?Q?Caf=E9_cr=E8me?=",
+			expected: "Café crème",
+		},
+		"base64 encoding": {
+			value:    "=?UTF-8?B?TcO8bmNoZW4=?=",
+			expected: "München",
+		},
+		"adjacent encoded words": {
+			value:    "=?UTF-8?Q?Gr=C3=BC=C3=9Fe_aus_?= =?UTF-8?B?TcO8bmNoZW4=?=",
+			expected: "Grüße aus München",
+		},
+		"encoded word within text": {
+			value:    "Re: =?UTF-8?Q?R=C3=A9union?= tomorrow",
+			expected: "Re: Réunion tomorrow",
+		},
+		"Shift_JIS": {
+			value:    "=?Shift_JIS?B?ie+LYw==?=",
+			expected: "会議",
+		},
+		"ISO-2022-JP": {
+			value:    "=?ISO-2022-JP?B?GyRCJCpDTiRpJDsbKEI=?=",
+			expected: "お知らせ",
+		},
+		"unknown charset": {
+			value:    "=?x-unknown?Q?hello?=",
+			expected: "=?x-unknown?Q?hello?=",
+		},
+		"unencoded UTF-8": {
+			value:    "Grüße",
+			expected: "Grüße",
+		},
+		"unencoded 8-bit text": {
+			value:    "Caf\xe9",
+			expected: "Café",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, decodeHeaderValue(test.value))
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"google.golang.org/api/gmail/v1"
+)
+
+const (
+	// propGmailMessageID and propGmailThreadID store the Gmail API IDs of the message a post was created from
+	propGmailMessageID = "gmail_message_id"
+	propGmailThreadID  = "gmail_thread_id"
+	// propGmailRFCMessageID stores the Message-ID header of the message a post was created from
+	propGmailRFCMessageID = "gmail_rfc_message_id"
+	// propGmailDate and propGmailInternalDate store the Date header of the message and the time (in milliseconds since epoch)
+	// Gmail received it at, for the notifications
+	propGmailDate         = "gmail_date"
+	propGmailInternalDate = "gmail_internal_date"
+
+	mailDateFormat = "Mon, Jan 2, 2006 at 3:04 PM MST"
+)
+
+// mailHeader holds the address lists in the headers of a mail
+type mailHeader struct {
+	From    []*mail.Address
+	Sender  *mail.Address
+	ReplyTo []*mail.Address
+	To      []*mail.Address
+	Cc      []*mail.Address
+	Bcc     []*mail.Address
+}
+
+// addresses returns the addresses in the headers, including the blind copied recipients only if asked for
+func (h *mailHeader) addresses(includeBcc bool) []*mail.Address {
+	addresses := []*mail.Address{}
+	addresses = append(addresses, h.From...)
+	if h.Sender != nil {
+		addresses = append(addresses, h.Sender)
+	}
+	addresses = append(addresses, h.ReplyTo...)
+	addresses = append(addresses, h.To...)
+	addresses = append(addresses, h.Cc...)
+	if includeBcc {
+		addresses = append(addresses, h.Bcc...)
+	}
+	return addresses
+}
+
+// parsedMessage holds the details of a mail shown in the post created from it
+type parsedMessage struct {
+	Subject string
+	Body    string
+	// HTMLBody is the HTML of the mail as received, which is to be sanitized before use
+	HTMLBody string
+	// QuotedBody is the quoted reply history removed from the body
+	QuotedBody  string
+	Date        time.Time
+	DateHeader  string
+	Header      mailHeader
+	MessageID   string
+	Attachments []mailFile
+	// EmbeddedFiles are the parts of the mail referred to from its body by their Content-ID, such as inline images
+	EmbeddedFiles []mailFile
+	// RawHeader holds the headers of the mail as received, used to reply to it
+	RawHeader mail.Header
+}
+
+// getMailPost creates the post of the Gmail message, rendering its headers as the fields of an attachment with the body as its text.
+// The Mattermost users having the addresses, keyed by their lowercased email, are mentioned in the pretext of the attachment,
+// as mentions in the fields do not notify the users. The blind copied recipients are shown only if showBcc is set,
+// ie. in the direct channel of the owner of the mailbox. The date is shown in the given location.
+func getMailPost(message *gmail.Message, parsed *parsedMessage, gmailID string, labelNames []string, usernames map[string]string, location *time.Location, sharingInfo string, showBcc bool) *model.Post {
+	subject := parsed.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+
+	header := parsed.Header
+	fields := []*model.SlackAttachmentField{
+		{Title: "From", Value: formatAddresses(header.From, usernames)},
+	}
+	// The sender is shown only when the mail is sent on behalf of someone else
+	if header.Sender != nil && !containsAddress(header.From, header.Sender) {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Sender", Value: formatAddresses([]*mail.Address{header.Sender}, usernames)})
+	}
+	if len(header.ReplyTo) > 0 && !sameAddresses(header.ReplyTo, header.From) {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Reply-To", Value: formatAddresses(header.ReplyTo, usernames)})
+	}
+	fields = append(fields, &model.SlackAttachmentField{Title: "To", Value: formatAddresses(header.To, usernames)})
+	if len(header.Cc) > 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Cc", Value: formatAddresses(header.Cc, usernames)})
+	}
+	if showBcc && len(header.Bcc) > 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Bcc", Value: formatAddresses(header.Bcc, usernames)})
+	}
+
+	date := "Unknown"
+	if !parsed.Date.IsZero() {
+		date = parsed.Date.In(location).Format(mailDateFormat)
+	} else if message.InternalDate != 0 {
+		// The time Gmail received the mail at is used when the mail has no valid Date header
+		date = time.Unix(0, message.InternalDate*int64(time.Millisecond)).In(location).Format(mailDateFormat)
+	}
+	fields = append(fields, &model.SlackAttachmentField{Title: "Date", Value: date, Short: true})
+	if len(labelNames) > 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Labels", Value: strings.Join(labelNames, ", "), Short: true})
+	}
+
+	gmailLink := getGmailMessageLink(gmailID, message.Id)
+	if gmailLink != "" {
+		fields = append(fields, &model.SlackAttachmentField{Value: "[Open in Gmail](" + gmailLink + ")"})
+	}
+
+	attachment := &model.SlackAttachment{
+		Fallback:  "Email from " + formatAddresses(header.From, nil) + ": " + subject,
+		Pretext:   formatMentions(header.addresses(showBcc), usernames),
+		Title:     subject,
+		TitleLink: gmailLink,
+		Text:      parsed.Body,
+		Fields:    fields,
+	}
+
+	return &model.Post{
+		Message: sharingInfo,
+		Props: map[string]interface{}{
+			propFromGmailPlugin:   true,
+			propGmailMessageID:    message.Id,
+			propGmailThreadID:     message.ThreadId,
+			propGmailRFCMessageID: parsed.MessageID,
+			"attachments":         []*model.SlackAttachment{attachment},
+		},
+	}
+}
+
+// formatAddresses lists the addresses with the names of their owners, if any, along with the usernames of the Mattermost users they belong to
+func formatAddresses(addresses []*mail.Address, usernames map[string]string) string {
+	if len(addresses) == 0 {
+		return "_Unknown_"
+	}
+
+	formatted := []string{}
+	for _, address := range addresses {
+		formattedAddress := address.Address
+		if address.Name != "" {
+			formattedAddress = address.Name + " (" + address.Address + ")"
+		}
+		if username, ok := usernames[strings.ToLower(address.Address)]; ok {
+			formattedAddress += " (" + username + ")"
+		}
+		formatted = append(formatted, formattedAddress)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// formatMentions mentions the Mattermost users the addresses belong to, once each
+func formatMentions(addresses []*mail.Address, usernames map[string]string) string {
+	mentions := []string{}
+	mentioned := map[string]bool{}
+	for _, address := range addresses {
+		username, ok := usernames[strings.ToLower(address.Address)]
+		if !ok || mentioned[username] {
+			continue
+		}
+		mentioned[username] = true
+		mentions = append(mentions, "@"+username)
+	}
+	return strings.Join(mentions, " ")
+}
+
+// containsAddress checks if the address is in the list, comparing the addresses ignoring case
+func containsAddress(addresses []*mail.Address, address *mail.Address) bool {
+	for _, listedAddress := range addresses {
+		if strings.EqualFold(listedAddress.Address, address.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameAddresses checks if both the lists have the same addresses
+func sameAddresses(addresses []*mail.Address, otherAddresses []*mail.Address) bool {
+	if len(addresses) != len(otherAddresses) {
+		return false
+	}
+	for _, address := range addresses {
+		if !containsAddress(otherAddresses, address) {
+			return false
+		}
+	}
+	return true
+}
+
+// getUsernamesByEmail finds the active Mattermost users with the given addresses as their email,
+// returning their usernames keyed by the lowercased address. The usernames are cached in the given map.
+func (p *Plugin) getUsernamesByEmail(addresses []*mail.Address, cache map[string]string) map[string]string {
+	usernames := map[string]string{}
+	for _, address := range addresses {
+		email := strings.ToLower(address.Address)
+		username, cached := cache[email]
+		if !cached {
+			if user, appErr := p.API.GetUserByEmail(email); appErr == nil && user.DeleteAt == 0 && !user.IsBot {
+				username = user.Username
+			}
+			cache[email] = username
+		}
+		if username != "" {
+			usernames[email] = username
+		}
+	}
+	return usernames
+}
+
+// getGmailMessageLink returns the URL opening the message in Gmail, in the account the message was fetched from
+func getGmailMessageLink(gmailID string, messageID string) string {
+	if messageID == "" {
+		return ""
+	}
+	account := "0"
+	if gmailID != "" {
+		account = url.PathEscape(gmailID)
+	}
+	return "https://mail.google.com/mail/u/" + account + "/#all/" + messageID
+}
+
+// getMessageLabelNames returns the names of the labels of the message, falling back to the ID for unknown labels
+func getMessageLabelNames(labels []*gmail.Label, message *gmail.Message) []string {
+	names := []string{}
+	for _, labelID := range message.LabelIds {
+		name := labelID
+		for _, label := range labels {
+			if label.Id == labelID {
+				name = label.Name
+				break
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestGetMailPostRecipients(t *testing.T) {
+	parsed := &parsedMessage{
+		Subject: "Weekly report",
+		Body:    "Please find the report attached.",
+		Header: mailHeader{
+			From: []*mail.Address{{Name: "Alice", Address: "alice@example.com"}},
+			To:   []*mail.Address{{Address: "Bob@example.com"}, {Address: "someone@example.org"}},
+			Cc:   []*mail.Address{{Name: "Alice", Address: "alice@example.com"}},
+			Bcc:  []*mail.Address{{Name: "Carol", Address: "carol@example.com"}},
+		},
+	}
+	usernames := map[string]string{
+		"alice@example.com": "alice",
+		"bob@example.com":   "bob",
+		"carol@example.com": "carol",
+	}
+
+	for name, test := range map[string]struct {
+		showBcc         bool
+		expectedPretext string
+		expectedFields  map[string]string
+	}{
+		"shared channel": {
+			expectedPretext: "@alice @bob",
+			expectedFields: map[string]string{
+				"From": "Alice (alice@example.com) (alice)",
+				"To":   "Bob@example.com (bob), someone@example.org",
+				"Cc":   "Alice (alice@example.com) (alice)",
+			},
+		},
+		"direct channel of the owner": {
+			showBcc:         true,
+			expectedPretext: "@alice @bob @carol",
+			expectedFields: map[string]string{
+				"From": "Alice (alice@example.com) (alice)",
+				"To":   "Bob@example.com (bob), someone@example.org",
+				"Cc":   "Alice (alice@example.com) (alice)",
+				"Bcc":  "Carol (carol@example.com) (carol)",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			post := getMailPost(&gmail.Message{Id: "17ae887b43575ed8"}, parsed, "someone@gmail.com", nil, usernames, time.UTC, "", test.showBcc)
+
+			attachments := post.Attachments()
+			require.Len(t, attachments, 1)
+			assert.Equal(t, test.expectedPretext, attachments[0].Pretext)
+			assert.Equal(t, "Weekly report", attachments[0].Title)
+			assert.Equal(t, "Please find the report attached.", attachments[0].Text)
+
+			fields := map[string]string{}
+			for _, field := range attachments[0].Fields {
+				if field.Title == "From" || field.Title == "To" || field.Title == "Cc" || field.Title == "Bcc" {
+					fields[field.Title] = field.Value.(string)
+				}
+			}
+			assert.Equal(t, test.expectedFields, fields)
+		})
+	}
+}
+
+func TestFormatMentions(t *testing.T) {
+	for name, test := range map[string]struct {
+		addresses []*mail.Address
+		expected  string
+	}{
+		"no users": {
+			addresses: []*mail.Address{{Address: "someone@example.org"}},
+		},
+		"users mentioned once": {
+			addresses: []*mail.Address{{Address: "alice@example.com"}, {Address: "ALICE@example.com"}, {Address: "bob@example.com"}},
+			expected:  "@alice @bob",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			usernames := map[string]string{"alice@example.com": "alice", "bob@example.com": "bob"}
+			assert.Equal(t, test.expected, formatMentions(test.addresses, usernames))
+		})
+	}
+}
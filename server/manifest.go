@@ -19,7 +19,7 @@ const manifestStr = `
   "support_url": "https://github.com/abdulsmapara/mattermost-plugin-gmail/issues",
   "release_notes_url": "https://github.com/abdulsmapara/mattermost-plugin-gmail/blob/master/CHANGELOG.md",
   "version": "0.1.1",
-  "min_server_version": "5.19.0",
+  "min_server_version": "5.20.0",
   "server": {
     "executables": {
       "linux-amd64": "server/dist/plugin-linux-amd64",
@@ -28,6 +28,9 @@ const manifestStr = `
     },
     "executable": ""
   },
+  "webapp": {
+    "bundle_path": "webapp/dist/main.js"
+  },
   "settings_schema": {
     "header": "The Gmail plugin for Mattermost",
     "footer": "Made with Love and Support from Mattermost by Abdul Sattar Mapara",
@@ -56,6 +59,48 @@ const manifestStr = `
         "placeholder": "Create a topic in Google Cloud pubsub",
         "default": null
       },
+      {
+        "key": "WebhookAuthenticationType",
+        "display_name": "Webhook Authentication",
+        "type": "radio",
+        "help_text": "How the plugin authenticates notifications pushed by the Pub/Sub subscription. 'JWT' verifies the OIDC token Google attaches when authentication is enabled on the subscription. 'Secret Token' requires the Webhook Secret to be passed as the 'token' query parameter of the endpoint URL.",
+        "placeholder": "",
+        "default": "jwt",
+        "options": [
+          {
+            "display_name": "JWT",
+            "value": "jwt"
+          },
+          {
+            "display_name": "Secret Token",
+            "value": "token"
+          }
+        ]
+      },
+      {
+        "key": "PubSubServiceAccountEmail",
+        "display_name": "Pub/Sub Service Account Email",
+        "type": "text",
+        "help_text": "Email of the service account used by the Pub/Sub subscription to sign tokens. Required when Webhook Authentication is JWT.",
+        "placeholder": "Service account selected while enabling authentication on the subscription",
+        "default": null
+      },
+      {
+        "key": "PubSubAudience",
+        "display_name": "Pub/Sub Audience",
+        "type": "text",
+        "help_text": "Audience configured on the Pub/Sub subscription. If empty, the endpoint URL \u003cMattermost server URL\u003e/plugins/mattermost-plugin-gmail/webhook/gmail is expected as the audience.",
+        "placeholder": "Leave empty if no audience was set on the subscription",
+        "default": null
+      },
+      {
+        "key": "WebhookSecret",
+        "display_name": "Webhook Secret",
+        "type": "generated",
+        "help_text": "The secret passed as the 'token' query parameter of the Pub/Sub endpoint URL. Required when Webhook Authentication is Secret Token.",
+        "placeholder": "Generate the secret if Webhook Authentication is Secret Token",
+        "default": null
+      },
       {
         "key": "EncryptionKey",
         "display_name": "Plugin Encryption Key",
@@ -63,6 +108,56 @@ const manifestStr = `
         "help_text": "The AES encryption key internally used in plugin to encrypt stored access tokens.",
         "placeholder": "Generate the key and store before connecting the account",
         "default": null
+      },
+      {
+        "key": "LongMailHandling",
+        "display_name": "Long Mails",
+        "type": "radio",
+        "help_text": "How the mails too long to fit in a post are posted. 'Split into replies' shows the beginning of the mail in its post and the rest in replies. 'Attach as a file' shows the beginning of the mail and attaches the full mail as an HTML or markdown file.",
+        "placeholder": "",
+        "default": "split",
+        "options": [
+          {
+            "display_name": "Split into replies",
+            "value": "split"
+          },
+          {
+            "display_name": "Attach as a file",
+            "value": "attach"
+          }
+        ]
+      },
+      {
+        "key": "MaxAttachmentSize",
+        "display_name": "Maximum Attachment Size (MB)",
+        "type": "text",
+        "help_text": "The attachments of mails larger than this size are not uploaded with the mails, but can be fetched on demand using the 'Fetch attachment' button. Leave empty or set to 0 to upload attachments of any size.",
+        "placeholder": "",
+        "default": "10"
+      },
+      {
+        "key": "AllowedAttachmentExtensions",
+        "display_name": "Allowed Attachment Extensions",
+        "type": "text",
+        "help_text": "Comma-separated list of the extensions of the attachments uploaded with the mails. Leave empty to allow all the extensions not blocked. Other attachments can be fetched on demand.",
+        "placeholder": "eg. pdf, png, jpg, docx",
+        "default": null
+      },
+      {
+        "key": "BlockedAttachmentExtensions",
+        "display_name": "Blocked Attachment Extensions",
+        "type": "text",
+        "help_text": "Comma-separated list of the extensions of the attachments not uploaded with the mails. These attachments can be fetched on demand.",
+        "placeholder": "",
+        "default": "exe, bat, cmd, com, msi, scr, vbs, js, jar"
+      },
+      {
+        "key": "ShowLinkTargets",
+        "display_name": "Show Link Targets",
+        "type": "bool",
+        "help_text": "When true, the URL of each link in HTML mails is shown after the link if its text is different. Links whose text looks like a different URL are always marked with their real URL.",
+        "placeholder": "",
+        "default": false
       }
     ]
   }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// outgoingMail is a mail to be sent from the Gmail account of a user
+type outgoingMail struct {
+	From       string
+	To         []string
+	Cc         []string
+	Bcc        []string
+	Subject    string
+	InReplyTo  string
+	References string
+	TextBody   string
+	// HTMLBody is sent as an alternative to TextBody, if not empty
+	HTMLBody    string
+	Attachments []*mailAttachment
+}
+
+// mailAttachment is a file attached to an outgoing mail
+type mailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// buildMIMEMessage builds the RFC 2822 message for the mail, returning the message along with its Message-ID
+func buildMIMEMessage(outgoing *outgoingMail) ([]byte, string, error) {
+	if len(outgoing.To)+len(outgoing.Cc)+len(outgoing.Bcc) == 0 {
+		return nil, "", errors.New("no recipient provided")
+	}
+
+	domain := "mattermost.local"
+	if index := strings.LastIndex(outgoing.From, "@"); index != -1 {
+		domain = outgoing.From[index+1:]
+	}
+	messageID := fmt.Sprintf("<%s@%s>", model.NewId(), domain)
+
+	var message bytes.Buffer
+	writeHeader(&message, "From", outgoing.From)
+	writeAddressHeader(&message, "To", outgoing.To)
+	writeAddressHeader(&message, "Cc", outgoing.Cc)
+	writeAddressHeader(&message, "Bcc", outgoing.Bcc)
+	writeHeader(&message, "Subject", mime.QEncoding.Encode("utf-8", outgoing.Subject))
+	writeHeader(&message, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&message, "Message-ID", messageID)
+	writeHeader(&message, "In-Reply-To", outgoing.InReplyTo)
+	writeHeader(&message, "References", outgoing.References)
+	writeHeader(&message, "MIME-Version", "1.0")
+
+	if err := writeContent(&message, outgoing); err != nil {
+		return nil, "", err
+	}
+	return message.Bytes(), messageID, nil
+}
+
+// writeContent writes the content headers followed by the content of the mail,
+// as multipart/mixed if the mail has attachments
+func writeContent(message *bytes.Buffer, outgoing *outgoingMail) error {
+	bodyHeader, body, err := buildBody(outgoing)
+	if err != nil {
+		return err
+	}
+
+	if len(outgoing.Attachments) == 0 {
+		writeHeader(message, "Content-Type", bodyHeader.Get("Content-Type"))
+		writeHeader(message, "Content-Transfer-Encoding", bodyHeader.Get("Content-Transfer-Encoding"))
+		message.WriteString("\r\n")
+		_, err = message.Write(body)
+		return err
+	}
+
+	var content bytes.Buffer
+	writer := multipart.NewWriter(&content)
+	writeHeader(message, "Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	message.WriteString("\r\n")
+
+	part, err := writer.CreatePart(bodyHeader)
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(body); err != nil {
+		return err
+	}
+
+	for _, attachment := range outgoing.Attachments {
+		contentType := mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Filename})
+		if contentType == "" {
+			contentType = mime.FormatMediaType("application/octet-stream", map[string]string{"name": attachment.Filename})
+		}
+
+		part, err = writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = part.Write(encodeBase64Lines(attachment.Data)); err != nil {
+			return err
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	_, err = message.Write(content.Bytes())
+	return err
+}
+
+// buildBody builds the content headers and the encoded body of the mail,
+// as multipart/alternative if the mail has an HTML body
+func buildBody(outgoing *outgoingMail) (textproto.MIMEHeader, []byte, error) {
+	if outgoing.HTMLBody == "" {
+		var content bytes.Buffer
+		if err := writeQuotedPrintable(&content, outgoing.TextBody); err != nil {
+			return nil, nil, err
+		}
+		return textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}, content.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	// The preferred alternative is the last one
+	alternatives := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", outgoing.TextBody},
+		{"text/html; charset=UTF-8", outgoing.HTMLBody},
+	}
+	for _, alternative := range alternatives {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {alternative.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var content bytes.Buffer
+		if err = writeQuotedPrintable(&content, alternative.content); err != nil {
+			return nil, nil, err
+		}
+		if _, err = part.Write(content.Bytes()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	return textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + writer.Boundary()},
+	}, body.Bytes(), nil
+}
+
+// writeHeader writes the header, skipping it if the value is empty
+func writeHeader(message *bytes.Buffer, name string, value string) {
+	if value == "" {
+		return
+	}
+	message.WriteString(name + ": " + value + "\r\n")
+}
+
+// writeAddressHeader writes the header listing the addresses, skipping it if there are no addresses
+func writeAddressHeader(message *bytes.Buffer, name string, addresses []string) {
+	writeHeader(message, name, strings.Join(addresses, ", "))
+}
+
+// writeQuotedPrintable writes the text encoded as quoted-printable, using CRLF line endings
+func writeQuotedPrintable(message *bytes.Buffer, text string) error {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\n", "\r\n", -1)
+
+	writer := quotedprintable.NewWriter(message)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// encodeBase64Lines encodes the data as base64, in lines of 76 characters as required for MIME bodies
+func encodeBase64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var lines bytes.Buffer
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded + "\r\n")
+	return lines.Bytes()
+}
+
+// parseAddressList parses comma-separated addresses, returning them formatted for use in headers
+func parseAddressList(addresses string) ([]string, error) {
+	if strings.TrimSpace(addresses) == "" {
+		return []string{}, nil
+	}
+
+	parsedAddresses, err := mail.ParseAddressList(addresses)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid address in "+addresses)
+	}
+
+	formattedAddresses := []string{}
+	for _, address := range parsedAddresses {
+		formattedAddresses = append(formattedAddresses, address.String())
+	}
+	return formattedAddresses, nil
+}
+
+// encodeRawMessage encodes the message as required by the Gmail API
+func encodeRawMessage(message []byte) string {
+	return base64.URLEncoding.EncodeToString(message)
+}
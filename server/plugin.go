@@ -1,12 +1,14 @@
 package main
 
 import (
+	"crypto/rsa"
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/pkg/errors"
 	"io/ioutil"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Plugin implements the interface expected by the Mattermost server to communicate between the server and plugin processes.
@@ -22,6 +24,23 @@ type Plugin struct {
 	// configuration is the active plugin configuration. Consult getConfiguration and
 	// setConfiguration for usage.
 	configuration *configuration
+
+	// googleKeysLock synchronizes access to the cached Google certificates used to verify webhook tokens.
+	googleKeysLock sync.Mutex
+
+	// googleKeys are the cached public keys of Google, keyed by key ID, fetched at googleKeysFetchedAt.
+	googleKeys          map[string]*rsa.PublicKey
+	googleKeysFetchedAt time.Time
+
+	// watchRenewalStop signals the watch renewal job to stop, which closes watchRenewalDone once stopped.
+	watchRenewalStop chan bool
+	watchRenewalDone chan bool
+
+	// bulkImportStop signals the running bulk import jobs to stop, and bulkImportJobs waits for them to stop.
+	// bulkImportStopLock guards bulkImportStop, as jobs are started by commands while the plugin may be deactivating.
+	bulkImportStop     chan bool
+	bulkImportStopLock sync.Mutex
+	bulkImportJobs     sync.WaitGroup
 }
 
 // OnActivate is invoked when the plugin is activated. If an error is returned, the plugin will be terminated.
@@ -35,13 +54,16 @@ func (p *Plugin) OnActivate() error {
 	if err != nil {
 		return err
 	}
+	if config.getWebhookAuthenticationType() == webhookAuthTypeToken && config.WebhookSecret == "" {
+		p.API.LogWarn("Gmail notifications are rejected until Webhook Authentication is configured in plugin settings")
+	}
 
 	// Register the command commandGmail
 	if err = p.API.RegisterCommand(&model.Command{
 		Trigger:          commandGmail,
 		AutoComplete:     true,
 		AutoCompleteHint: "[command]",
-		AutoCompleteDesc: "Available Commands: connect, disconnect, subscribe, unsubscribe, import, subscriptions, help",
+		AutoCompleteDesc: "Available Commands: connect, disconnect, subscribe, unsubscribe, import, unfollow, search, subscriptions, labels, send, help",
 	}); err != nil {
 		errorMessage := "failed to register command " + commandGmail
 		p.API.LogError(errorMessage, "err", err.Error())
@@ -88,5 +110,18 @@ func (p *Plugin) OnActivate() error {
 		return errors.Wrap(err, "Could not set the profile image")
 	}
 
+	// Renew Gmail watches before they expire
+	p.startWatchRenewalJob()
+
+	// Resume the imports interrupted by a restart
+	p.resumeBulkImportJobs()
+
+	return nil
+}
+
+// OnDeactivate is invoked when the plugin is deactivated.
+func (p *Plugin) OnDeactivate() error {
+	p.stopWatchRenewalJob()
+	p.stopBulkImportJobs()
 	return nil
 }
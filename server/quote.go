@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// quotedTextExpiry is the period after which the quoted reply history stored for a post is deleted,
+// as it is not deleted along with the post
+const quotedTextExpiry = 365 * 24 * time.Hour
+
+var (
+	// attributionPattern matches the line introducing the quoted reply history, eg. "On Mon, Jan 2, 2006, Someone wrote:"
+	attributionPattern = regexp.MustCompile(`(?i)^On\s.*\swrote:$`)
+	// originalMessagePattern matches the separators of the quoted reply history used by Outlook
+	originalMessagePattern = regexp.MustCompile(`(?i)^(-{2,}\s*Original Message\s*-{2,}|_{10,})$`)
+	// outlookHeaderPattern matches the headers of the quoted message in the reply history added by Outlook
+	outlookHeaderPattern = regexp.MustCompile(`(?i)^(From|Sent|Date|To|Cc|Subject):\s`)
+)
+
+// splitQuotedText splits the quoted reply history from the end of the plain text or markdown body of a mail.
+// The history is recognized by lines quoted with '>', the "On ... wrote:" line introducing it,
+// and the separators and headers of the quoted message added by Outlook.
+// Nothing is split if the body has no text other than the history.
+func splitQuotedText(body string) (string, string) {
+	lines := strings.Split(strings.Replace(body, "\r\n", "\n", -1), "\n")
+	// The markdown of HTML mails has the headers in bold
+	plainLine := func(i int) string {
+		return strings.TrimSpace(strings.Replace(strings.Replace(lines[i], "*", "", -1), "__", "", -1))
+	}
+
+	start := len(lines)
+	for i := range lines {
+		line := plainLine(i)
+		if originalMessagePattern.MatchString(line) && i+1 < len(lines) && strings.HasPrefix(strings.ToLower(plainLine(i+1)), "from:") {
+			start = i
+			break
+		}
+		// The headers of forwarded messages are kept
+		forwarded := i > 0 && strings.Contains(strings.ToLower(plainLine(i-1)), "forwarded message")
+		if strings.HasPrefix(strings.ToLower(line), "from:") && !forwarded && isOutlookHeader(lines, i, plainLine) {
+			start = i
+			break
+		}
+		if attributionPattern.MatchString(line) && isQuotedFrom(lines, i+1) {
+			start = i
+			break
+		}
+		// The attribution can be wrapped into two lines
+		if i+1 < len(lines) && attributionPattern.MatchString(line+" "+plainLine(i+1)) && isQuotedFrom(lines, i+2) {
+			start = i
+			break
+		}
+		if strings.HasPrefix(line, ">") && isQuotedFrom(lines, i) {
+			start = i
+			break
+		}
+	}
+
+	text := strings.TrimSpace(strings.Join(lines[:start], "\n"))
+	if text == "" {
+		return body, ""
+	}
+	return text, strings.TrimSpace(strings.Join(lines[start:], "\n"))
+}
+
+// isOutlookHeader checks if the From: line at the index is followed by the other headers of the quoted message added by Outlook
+func isOutlookHeader(lines []string, index int, plainLine func(int) string) bool {
+	headers := 0
+	for i := index + 1; i < len(lines) && i <= index+4; i++ {
+		if outlookHeaderPattern.MatchString(plainLine(i)) {
+			headers++
+		}
+	}
+	return headers >= 2
+}
+
+// isQuotedFrom checks if all the lines from the index are quoted with '>', or are blank, with at least one quoted line
+func isQuotedFrom(lines []string, index int) bool {
+	quoted := false
+	for i := index; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, ">") {
+			return false
+		}
+		quoted = true
+	}
+	return quoted
+}
+
+// addQuotedTextAction adds the button to show the quoted reply history removed from the mail to its post
+func (p *Plugin) addQuotedTextAction(post *model.Post) {
+	attachments := post.Attachments()
+	if len(attachments) == 0 {
+		return
+	}
+	attachments[0].Actions = append(attachments[0].Actions, p.getQuotedTextAction("Show quoted text", ActionShowQuotedText))
+}
+
+// getQuotedTextAction creates the button to show or hide the quoted reply history of the mail
+func (p *Plugin) getQuotedTextAction(name string, action string) *model.PostAction {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	return &model.PostAction{
+		Type: model.POST_ACTION_TYPE_BUTTON,
+		Name: name,
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("%s/plugins/%s/command/quote", siteURL, manifest.Id),
+			Context: map[string]interface{}{
+				"action":       action,
+				"actionSecret": p.getActionSecret(),
+			},
+		},
+	}
+}
+
+// storeQuotedText stores the quoted reply history removed from the mail of the post, to be shown on demand
+func (p *Plugin) storeQuotedText(postID string, quotedText string) error {
+	if _, appErr := p.API.KVSetWithOptions(postID+"quotedText", []byte(quotedText), model.PluginKVSetOptions{
+		ExpireInSeconds: int64(quotedTextExpiry / time.Second),
+	}); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// formatQuotedText formats the quoted reply history as a markdown quote, to be appended to the mail
+func formatQuotedText(quotedText string) string {
+	lines := strings.Split(strings.TrimSpace(quotedText), "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return "\n\n" + strings.Join(lines, "\n")
+}
+
+// handleQuoteAction shows or hides the quoted reply history in the post of the mail.
+// When it cannot be added to the post, the quoted reply history is shown only to the user.
+func (p *Plugin) handleQuoteAction(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	integrationRequest := model.PostActionIntegrationRequestFromJson(r.Body)
+	if integrationRequest == nil || integrationRequest.UserId != authUserID {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	channelID := integrationRequest.ChannelId
+	actionToBeTaken, _ := integrationRequest.Context["action"].(string)
+	actionSecretPassed, _ := integrationRequest.Context["actionSecret"].(string)
+
+	if !p.isValidActionSecret(actionSecretPassed) {
+		http.Error(w, "Unauthorized quote action detected", http.StatusBadRequest)
+		return
+	}
+	if actionToBeTaken != ActionShowQuotedText && actionToBeTaken != ActionHideQuotedText {
+		http.Error(w, "Unknown quote action detected", http.StatusBadRequest)
+		return
+	}
+
+	post, appErr := p.API.GetPost(integrationRequest.PostId)
+	if appErr != nil {
+		http.Error(w, "Could not get the post", http.StatusInternalServerError)
+		return
+	}
+	attachments := post.Attachments()
+	quotedText, appErr := p.API.KVGet(post.Id + "quotedText")
+	if appErr != nil || quotedText == nil || len(attachments) == 0 {
+		p.sendMessageFromBot(channelID, authUserID, true, "The quoted text of the mail is no longer available.")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	formattedQuote := formatQuotedText(string(quotedText))
+	attachment := attachments[0]
+	text := attachment.Text
+
+	if actionToBeTaken == ActionShowQuotedText {
+		attachment.Text = text + formattedQuote
+		p.setQuotedTextAction(attachment, "Hide quoted text", ActionHideQuotedText)
+	} else {
+		attachment.Text = strings.TrimSuffix(text, formattedQuote)
+		p.setQuotedTextAction(attachment, "Show quoted text", ActionShowQuotedText)
+	}
+	post.AddProp("attachments", attachments)
+
+	// The quoted text is not added after the beginning of a mail continued in the replies, nor when the post would be too large
+	continued := strings.HasSuffix(text, longMailContinuedNote)
+	if continued || utf8.RuneCountInString(model.StringInterfaceToJson(post.GetProps())) > model.POST_PROPS_MAX_USER_RUNES {
+		quote, _ := splitMailBody(strings.TrimSpace(formattedQuote), func(quote string) bool {
+			return utf8.RuneCountInString(quote) <= model.POST_MESSAGE_MAX_RUNES_V2
+		})
+		p.sendMessageFromBot(channelID, authUserID, true, quote)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if _, appErr = p.API.UpdatePost(post); appErr != nil {
+		p.API.LogError("Could not update the post of the mail", "err", appErr.Error())
+		http.Error(w, "Could not update the post", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setQuotedTextAction replaces the button to show or hide the quoted reply history in the attachment of the mail
+func (p *Plugin) setQuotedTextAction(attachment *model.SlackAttachment, name string, action string) {
+	for _, postAction := range attachment.Actions {
+		if postAction.Integration == nil {
+			continue
+		}
+		if quoteAction, _ := postAction.Integration.Context["action"].(string); quoteAction == ActionShowQuotedText || quoteAction == ActionHideQuotedText {
+			postAction.Name = name
+			postAction.Integration = p.getQuotedTextAction(name, action).Integration
+		}
+	}
+}
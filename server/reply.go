@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+	"google.golang.org/api/gmail/v1"
+)
+
+// propFromGmailPlugin marks the posts created by the plugin from Gmail messages
+const propFromGmailPlugin = "from_gmail_plugin"
+
+// gmailPostInfoExpiry is the period after which the details stored to reply to a message by email are deleted,
+// as they are not deleted along with the post
+const gmailPostInfoExpiry = 365 * 24 * time.Hour
+
+// gmailPostInfo links a post created from a Gmail message to the message, to be able to reply to it
+type gmailPostInfo struct {
+	// UserID is the ID of the Mattermost user whose Gmail account the message was fetched from
+	UserID       string
+	GmailID      string
+	MessageID    string
+	ThreadID     string
+	RFCMessageID string
+	References   string
+	Subject      string
+	From         []string
+	To           []string
+	ReplyTo      []string
+}
+
+// newGmailPostInfo extracts the details required to reply to the message from its headers
+func newGmailPostInfo(userID string, gmailID string, message *gmail.Message, header mail.Header) *gmailPostInfo {
+	return &gmailPostInfo{
+		UserID:       userID,
+		GmailID:      gmailID,
+		MessageID:    message.Id,
+		ThreadID:     message.ThreadId,
+		RFCMessageID: strings.TrimSpace(header.Get("Message-ID")),
+		References:   strings.Join(strings.Fields(header.Get("References")), " "),
+		Subject:      decodeHeaderValue(header.Get("Subject")),
+		From:         getHeaderAddresses(header, "From"),
+		To:           getHeaderAddresses(header, "To"),
+		ReplyTo:      getHeaderAddresses(header, "Reply-To"),
+	}
+}
+
+// getHeaderAddresses returns the addresses in the header formatted for use in headers, ignoring invalid ones
+func getHeaderAddresses(header mail.Header, name string) []string {
+	addresses := []string{}
+	for _, address := range parseHeaderAddresses(header, name) {
+		addresses = append(addresses, address.String())
+	}
+	return addresses
+}
+
+// storeGmailPostInfo stores the details of the Gmail message the post was created from
+func (p *Plugin) storeGmailPostInfo(postID string, postInfo *gmailPostInfo) error {
+	postInfoJSON, err := json.Marshal(postInfo)
+	if err != nil {
+		return err
+	}
+	if _, appErr := p.API.KVSetWithOptions(postID+"gmailMessage", postInfoJSON, model.PluginKVSetOptions{
+		ExpireInSeconds: int64(gmailPostInfoExpiry / time.Second),
+	}); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// storeGmailPostInfoForMessage stores the details of the Gmail message the post was created from,
+// also marking it as the latest message of the thread with the given root post
+func (p *Plugin) storeGmailPostInfoForMessage(postID string, rootID string, userID string, gmailID string, message *gmail.Message, header mail.Header) error {
+	postInfo := newGmailPostInfo(userID, gmailID, message, header)
+	if err := p.storeGmailPostInfo(postID, postInfo); err != nil {
+		return err
+	}
+	if _, appErr := p.API.KVSetWithOptions(rootID+"gmailLatestMessage", []byte(postID), model.PluginKVSetOptions{
+		ExpireInSeconds: int64(gmailPostInfoExpiry / time.Second),
+	}); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getGmailPostInfo returns the details of the Gmail message the post was created from,
+// nil if not created from a message or if the post has been deleted
+func (p *Plugin) getGmailPostInfo(postID string) (*gmailPostInfo, error) {
+	postInfoJSON, appErr := p.API.KVGet(postID + "gmailMessage")
+	if appErr != nil {
+		return nil, appErr
+	}
+	if postInfoJSON == nil {
+		return nil, nil
+	}
+	if _, appErr = p.API.GetPost(postID); appErr != nil {
+		p.API.KVDelete(postID + "gmailMessage")
+		return nil, nil
+	}
+
+	var postInfo gmailPostInfo
+	if err := json.Unmarshal(postInfoJSON, &postInfo); err != nil {
+		return nil, err
+	}
+	return &postInfo, nil
+}
+
+// MessageHasBeenPosted is invoked after the message has been committed to the database.
+// Replies in threads created from Gmail messages are offered to be sent as an email reply.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	if post.RootId == "" || post.UserId == p.gmailBotID || post.Type != "" || post.Message == "" {
+		return
+	}
+	if fromPlugin, _ := post.Props[propFromGmailPlugin].(bool); fromPlugin {
+		return
+	}
+	if !p.checkIfConnected(post.UserId) {
+		return
+	}
+
+	postInfo, err := p.getRepliedGmailPostInfo(post)
+	if err != nil {
+		p.API.LogError("Could not fetch Gmail message details of the thread", "err", err.Error())
+		return
+	}
+	if postInfo == nil {
+		return
+	}
+
+	siteURL := p.API.GetConfig().ServiceSettings.SiteURL
+	if siteURL == nil {
+		return
+	}
+	actionSecret := p.getActionSecret()
+	actionURL := fmt.Sprintf("%s/plugins/%s/command/reply", *siteURL, manifest.Id)
+
+	sendButton := &model.PostAction{
+		Type: model.POST_ACTION_TYPE_BUTTON,
+		Name: "Send",
+		Integration: &model.PostActionIntegration{
+			URL: actionURL,
+			Context: map[string]interface{}{
+				"action":       ActionSendReply,
+				"actionSecret": actionSecret,
+				"postID":       post.Id,
+			},
+		},
+	}
+
+	cancelButton := &model.PostAction{
+		Type: model.POST_ACTION_TYPE_BUTTON,
+		Name: "Cancel",
+		Integration: &model.PostActionIntegration{
+			URL: actionURL,
+			Context: map[string]interface{}{
+				"action":       ActionCancel,
+				"actionSecret": actionSecret,
+				"postID":       post.Id,
+			},
+		},
+	}
+
+	p.API.SendEphemeralPost(post.UserId, &model.Post{
+		UserId:    p.gmailBotID,
+		ChannelId: post.ChannelId,
+		RootId:    post.RootId,
+		Props: map[string]interface{}{
+			"attachments": []*model.SlackAttachment{{
+				Title:   "Reply by email",
+				Text:    "This thread was created from an email with the subject: **" + postInfo.Subject + "**. Would you like to send your reply as an email to " + strings.Join(getReplyRecipients(postInfo), ", ") + "?",
+				Actions: []*model.PostAction{sendButton, cancelButton},
+			}},
+		},
+	})
+}
+
+// getRepliedGmailPostInfo returns the details of the Gmail message replied to by the post, nil if the thread was not created from a Gmail message
+func (p *Plugin) getRepliedGmailPostInfo(post *model.Post) (*gmailPostInfo, error) {
+	if post.ParentId != "" && post.ParentId != post.RootId {
+		postInfo, err := p.getGmailPostInfo(post.ParentId)
+		if err != nil || postInfo != nil {
+			return postInfo, err
+		}
+	}
+
+	// Reply to the latest message of the thread
+	latestPostID, appErr := p.API.KVGet(post.RootId + "gmailLatestMessage")
+	if appErr != nil {
+		return nil, appErr
+	}
+	if latestPostID != nil {
+		postInfo, err := p.getGmailPostInfo(string(latestPostID))
+		if err != nil || postInfo != nil {
+			return postInfo, err
+		}
+	}
+	return p.getGmailPostInfo(post.RootId)
+}
+
+// getReplyRecipients returns the addresses the reply to the message is sent to
+func getReplyRecipients(postInfo *gmailPostInfo) []string {
+	if len(postInfo.ReplyTo) > 0 {
+		return postInfo.ReplyTo
+	}
+
+	// Reply to the recipients of the message if it was sent by the user
+	for _, from := range postInfo.From {
+		if address, err := mail.ParseAddress(from); err == nil && strings.EqualFold(address.Address, postInfo.GmailID) && len(postInfo.To) > 0 {
+			return postInfo.To
+		}
+	}
+	return postInfo.From
+}
+
+// handleReplyAction sends or discards the reply in the thread as an email, as confirmed by the user
+func (p *Plugin) handleReplyAction(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	integrationRequest := model.PostActionIntegrationRequestFromJson(r.Body)
+	if integrationRequest == nil || integrationRequest.UserId != authUserID {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID := integrationRequest.UserId
+	channelID := integrationRequest.ChannelId
+	originalPostID := integrationRequest.PostId
+	actionToBeTaken, _ := integrationRequest.Context["action"].(string)
+	postID, _ := integrationRequest.Context["postID"].(string)
+	actionSecretPassed, _ := integrationRequest.Context["actionSecret"].(string)
+
+	if !p.isValidActionSecret(actionSecretPassed) {
+		http.Error(w, "Unauthorized reply action detected", http.StatusBadRequest)
+		return
+	}
+
+	updateMessage := func(message string) {
+		p.API.UpdateEphemeralPost(userID, &model.Post{
+			Id:        originalPostID,
+			UserId:    p.gmailBotID,
+			ChannelId: channelID,
+			Message:   message,
+		})
+	}
+
+	switch actionToBeTaken {
+	case ActionCancel:
+		updateMessage("The reply was not sent as an email.")
+	case ActionSendReply:
+		recipients, err := p.sendReply(userID, postID)
+		if err != nil {
+			p.API.LogError("Could not send the reply as an email", "err", err.Error())
+			updateMessage("Unable to send the reply as an email. Please try again later.")
+			break
+		}
+		updateMessage("The reply was sent as an email to " + strings.Join(recipients, ", ") + ".")
+	default:
+		http.Error(w, "Unknown reply action detected", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendReply sends the post as an email reply to the Gmail message of the thread, returning the recipients
+func (p *Plugin) sendReply(userID string, postID string) ([]string, error) {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if post.UserId != userID {
+		return nil, errors.New("only the author of the post can send it as an email")
+	}
+
+	postInfo, err := p.getRepliedGmailPostInfo(post)
+	if err != nil {
+		return nil, err
+	}
+	if postInfo == nil {
+		return nil, errors.New("the thread was not created from a Gmail message")
+	}
+
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Thread IDs are specific to a mailbox, so find the thread in the mailbox of the user if it was imported by someone else
+	threadID := postInfo.ThreadID
+	if !strings.EqualFold(gmailID, postInfo.GmailID) {
+		threadID = ""
+		if postInfo.RFCMessageID != "" {
+			if userThreadID, threadErr := p.getThreadID(userID, gmailID, postInfo.RFCMessageID); threadErr == nil {
+				threadID = userThreadID
+			}
+		}
+	}
+
+	subject := postInfo.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+	references := strings.TrimSpace(postInfo.References + " " + postInfo.RFCMessageID)
+	recipients := getReplyRecipients(postInfo)
+
+	_, err = p.sendMail(userID, gmailID, &outgoingMail{
+		From:       gmailID,
+		To:         recipients,
+		Subject:    subject,
+		InReplyTo:  postInfo.RFCMessageID,
+		References: references,
+		TextBody:   post.Message,
+	}, threadID)
+	if err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
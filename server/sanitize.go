@@ -0,0 +1,215 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	html2markdown "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// quotedHistorySelector matches the quoted reply history in HTML mails, as added by Gmail, Apple Mail and Yahoo Mail
+const quotedHistorySelector = "div.gmail_quote, blockquote[type=cite], div.yahoo_quoted"
+
+// unsafeElementsSelector matches the elements whose content is not to be shown
+const unsafeElementsSelector = "script, style, iframe, frame, object, embed, form, input, button, select, textarea, link, base"
+
+var (
+	// hiddenStylePattern matches inline styles hiding the element
+	hiddenStylePattern = regexp.MustCompile(`(?i)(display\s*:\s*none|visibility\s*:\s*hidden|opacity\s*:\s*0(\.0+)?\s*(;|$)|(^|;)\s*(max-)?(width|height)\s*:\s*[01](px)?\s*(;|$))`)
+
+	// linkLikeTextPattern matches link text that looks like a URL or a domain
+	linkLikeTextPattern = regexp.MustCompile(`(?i)^(https?://)?([a-z0-9-]+\.)+[a-z]{2,}(/\S*)?$`)
+)
+
+// outlookQuoteSelector matches the element Outlook adds before the quoted reply history, which follows it
+const outlookQuoteSelector = "#appendonsend, #divRplyFwdMsg"
+
+// convertMailHTML converts the body of the HTML mail to markdown after sanitizing it,
+// returning the quoted reply history separately unless it is to be kept
+func convertMailHTML(htmlBody string, showLinkTargets bool, keepQuotes bool) (string, string, error) {
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
+	if err != nil {
+		return "", "", err
+	}
+	sanitizeMailHTML(document, showLinkTargets)
+	quotedHTML := ""
+	if !keepQuotes {
+		quotedHTML = removeQuotedHistory(document)
+	}
+
+	converter := html2markdown.NewConverter("", true, nil)
+	body := converter.Convert(document.Selection)
+	if quotedHTML == "" {
+		return body, "", nil
+	}
+
+	quoted, err := converter.ConvertString(quotedHTML)
+	if err != nil {
+		return "", "", err
+	}
+	return body, quoted, nil
+}
+
+// sanitizeMailHTML removes the hidden and unsafe content of the HTML mail, such as tracking images and links
+// with URLs other than http(s) and mailto, and marks the links whose text is a URL different from their target.
+// The targets of all the links whose text differs from their URL are shown if showLinkTargets is set.
+func sanitizeMailHTML(document *goquery.Document, showLinkTargets bool) {
+	document.Find(unsafeElementsSelector).Remove()
+
+	document.Find("[style]").Each(func(_ int, element *goquery.Selection) {
+		style, _ := element.Attr("style")
+		if hiddenStylePattern.MatchString(style) {
+			element.Remove()
+		}
+	})
+	document.Find("[hidden]").Remove()
+
+	// Event handlers run scripts, when the sanitized HTML is opened as a file
+	document.Find("*").Each(func(_ int, element *goquery.Selection) {
+		handlers := []string{}
+		for _, attribute := range element.Get(0).Attr {
+			if strings.HasPrefix(strings.ToLower(attribute.Key), "on") {
+				handlers = append(handlers, attribute.Key)
+			}
+		}
+		for _, handler := range handlers {
+			element.RemoveAttr(handler)
+		}
+	})
+
+	document.Find("img").Each(func(_ int, image *goquery.Selection) {
+		if isTrackingImage(image) || !isSafeURL(image.AttrOr("src", ""), "http", "https", "cid") {
+			image.Remove()
+		}
+	})
+
+	document.Find("a").Each(func(_ int, link *goquery.Selection) {
+		href := strings.TrimSpace(link.AttrOr("href", ""))
+		if !isSafeURL(href, "http", "https", "mailto") {
+			// Keep the text of the link, without the link
+			link.ReplaceWithSelection(link.Contents())
+			return
+		}
+
+		text := strings.TrimSpace(link.Text())
+		if text == "" || sameLinkTarget(text, href) {
+			return
+		}
+		if isDeceptiveLink(text, href) {
+			link.AfterHtml(" (link to " + html.EscapeString(href) + ")")
+		} else if showLinkTargets {
+			link.AfterHtml(" (" + html.EscapeString(href) + ")")
+		}
+	})
+}
+
+// sanitizeMailHTMLFile sanitizes the HTML mail as done before converting it to markdown, returning the sanitized HTML
+// to be uploaded as a file. The mail, decoded to UTF-8 while parsing it, is marked as UTF-8 in place of its original charset.
+func sanitizeMailHTMLFile(htmlBody string, showLinkTargets bool) (string, error) {
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
+	if err != nil {
+		return "", err
+	}
+	sanitizeMailHTML(document, showLinkTargets)
+	document.Find("meta").Remove()
+	document.Find("head").PrependHtml(`<meta charset="utf-8">`)
+	return document.Html()
+}
+
+// removeQuotedHistory removes the quoted reply history from the HTML mail, returning it as HTML
+func removeQuotedHistory(document *goquery.Document) string {
+	quoted := []string{}
+	document.Find(quotedHistorySelector).Each(func(_ int, quote *goquery.Selection) {
+		// Quotes nested in the quoted history are removed along with it
+		if quote.ParentsFiltered(quotedHistorySelector).Length() > 0 {
+			return
+		}
+		if quotedHTML, err := goquery.OuterHtml(quote); err == nil {
+			quoted = append(quoted, quotedHTML)
+		}
+		quote.Remove()
+	})
+
+	// Outlook does not mark the quoted reply history, which is after its separator
+	separator := document.Find(outlookQuoteSelector).First()
+	if separator.Length() > 0 {
+		history := separator.AddSelection(separator.NextAll())
+		for parent := separator.Parent(); parent.Length() > 0 && !parent.Is("body, html"); parent = parent.Parent() {
+			history = history.AddSelection(parent.NextAll())
+		}
+		history.Each(func(_ int, element *goquery.Selection) {
+			if quotedHTML, err := goquery.OuterHtml(element); err == nil {
+				quoted = append(quoted, quotedHTML)
+			}
+		})
+		history.Remove()
+	}
+	return strings.Join(quoted, "\n")
+}
+
+// isTrackingImage checks if the image is too small to be seen, as the images used to track the opening of mails
+func isTrackingImage(image *goquery.Selection) bool {
+	for _, dimension := range []string{"width", "height"} {
+		value, ok := image.Attr(dimension)
+		if !ok {
+			continue
+		}
+		if size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "px")); err == nil && size <= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// isSafeURL checks if the URL is absolute, with one of the given schemes
+func isSafeURL(rawURL string, schemes ...string) bool {
+	parsedURL, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return false
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(parsedURL.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameLinkTarget checks if the text of the link is its URL, ignoring the scheme and the trailing slash
+func sameLinkTarget(text string, href string) bool {
+	normalize := func(link string) string {
+		link = strings.ToLower(strings.TrimSpace(link))
+		for _, prefix := range []string{"https://", "http://", "mailto:"} {
+			link = strings.TrimPrefix(link, prefix)
+		}
+		return strings.TrimSuffix(link, "/")
+	}
+	return normalize(text) == normalize(href)
+}
+
+// isDeceptiveLink checks if the text of the link looks like a URL of a host other than the target of the link
+func isDeceptiveLink(text string, href string) bool {
+	if !linkLikeTextPattern.MatchString(text) {
+		return false
+	}
+	textURL := text
+	if !strings.Contains(textURL, "://") {
+		textURL = "http://" + textURL
+	}
+
+	parsedText, err := url.Parse(textURL)
+	if err != nil {
+		return true
+	}
+	parsedHref, err := url.Parse(href)
+	if err != nil {
+		return true
+	}
+	textHost := strings.TrimPrefix(strings.ToLower(parsedText.Hostname()), "www.")
+	hrefHost := strings.TrimPrefix(strings.ToLower(parsedHref.Hostname()), "www.")
+	return textHost != hrefHost
+}
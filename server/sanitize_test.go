@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeMailHTMLFile(t *testing.T) {
+	for name, test := range map[string]struct {
+		htmlBody    string
+		contains    []string
+		notContains []string
+	}{
+		"scripts and event handlers": {
+			htmlBody:    `<html><body><p onclick="steal()" ONMOUSEOVER="steal()" class="note">Hello</p><script>steal()</script></body></html>`,
+			contains:    []string{`<p class="note">Hello</p>`},
+			notContains: []string{"steal", "<script"},
+		},
+		"unsafe links": {
+			htmlBody:    `<a href="javascript:steal()">Click</a> <a href="https://example.com/page">Page</a>`,
+			contains:    []string{"Click", `<a href="https://example.com/page">Page</a>`},
+			notContains: []string{"javascript:"},
+		},
+		"tracking and hidden content": {
+			htmlBody:    `<p>Visible</p><img src="https://tracker.example.com/open.gif" width="1" height="1"><div style="display:none">Hidden</div>`,
+			contains:    []string{"Visible"},
+			notContains: []string{"tracker.example.com", "Hidden"},
+		},
+		"external resources": {
+			htmlBody:    `<html><head><base href="https://evil.example.com/"><link rel="stylesheet" href="https://example.com/style.css"></head><body>Hello</body></html>`,
+			contains:    []string{"Hello"},
+			notContains: []string{"<base", "<link"},
+		},
+		"original charset": {
+			htmlBody:    `<html><head><meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"></head><body>Café</body></html>`,
+			contains:    []string{`<meta charset="utf-8"/>`, "Café"},
+			notContains: []string{"iso-8859-1"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sanitizedHTML, err := sanitizeMailHTMLFile(test.htmlBody, false)
+			require.NoError(t, err)
+			for _, expected := range test.contains {
+				assert.Contains(t, sanitizedHTML, expected)
+			}
+			for _, unexpected := range test.notContains {
+				assert.NotContains(t, sanitizedHTML, unexpected)
+			}
+		})
+	}
+}
+
+func TestConvertMailHTML(t *testing.T) {
+	for name, test := range map[string]struct {
+		htmlBody         string
+		showLinkTargets  bool
+		keepQuotes       bool
+		contains         []string
+		notContains      []string
+		expectedQuoted   []string
+		expectedNoQuoted bool
+	}{
+		"tracking pixels": {
+			htmlBody:    `<p>Hello</p><img src="https://tracker.example.com/open.gif" width="1" height="1"><img src="https://tracker.example.com/pixel.png" style="width:0;height:0"><img src="https://example.com/chart.png" alt="Chart">`,
+			contains:    []string{"Hello", "![Chart](https://example.com/chart.png)"},
+			notContains: []string{"tracker.example.com"},
+		},
+		"hidden elements": {
+			htmlBody:    `<p>Visible</p><div style="display: none">Preheader</div><span style="visibility:hidden">Hidden span</span><p hidden>Hidden paragraph</p><p style="opacity:0">Transparent</p>`,
+			contains:    []string{"Visible"},
+			notContains: []string{"Preheader", "Hidden span", "Hidden paragraph", "Transparent"},
+		},
+		"javascript links": {
+			htmlBody:    `<p><a href="javascript:steal()">Claim your prize</a> or <a href="JavaScript:steal()">this one</a></p>`,
+			contains:    []string{"Claim your prize", "this one"},
+			notContains: []string{"javascript:", "JavaScript:", "]("},
+		},
+		"deceptive link text": {
+			htmlBody: `<p><a href="https://evil.example.net/login">https://www.mybank.com/login</a></p>`,
+			contains: []string{"(link to https://evil.example.net/login)"},
+		},
+		"link text matching the target": {
+			htmlBody:    `<p><a href="https://www.example.com/page">www.example.com/page</a></p>`,
+			notContains: []string{"link to"},
+		},
+		"link targets shown": {
+			htmlBody:        `<p><a href="https://example.com/unsubscribe">Unsubscribe</a></p>`,
+			showLinkTargets: true,
+			contains:        []string{"[Unsubscribe](https://example.com/unsubscribe) (https://example.com/unsubscribe)"},
+		},
+		"quoted history": {
+			htmlBody:       `<div>Sounds good.</div><div class="gmail_quote"><div>On Mon, Sep 14, 2020 Someone wrote:</div><blockquote>Shall we meet?</blockquote></div>`,
+			contains:       []string{"Sounds good."},
+			notContains:    []string{"Shall we meet?"},
+			expectedQuoted: []string{"Shall we meet?"},
+		},
+		"quoted history kept": {
+			htmlBody:         `<div>Sounds good.</div><div class="gmail_quote"><blockquote>Shall we meet?</blockquote></div>`,
+			keepQuotes:       true,
+			contains:         []string{"Sounds good.", "Shall we meet?"},
+			expectedNoQuoted: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			body, quoted, err := convertMailHTML(test.htmlBody, test.showLinkTargets, test.keepQuotes)
+			require.NoError(t, err)
+			for _, expected := range test.contains {
+				assert.Contains(t, body, expected)
+			}
+			for _, unexpected := range test.notContains {
+				assert.NotContains(t, body, unexpected)
+			}
+			for _, expected := range test.expectedQuoted {
+				assert.Contains(t, quoted, expected)
+			}
+			if test.expectedNoQuoted {
+				assert.Empty(t, quoted)
+			}
+		})
+	}
+}
+
+func TestSplitQuotedText(t *testing.T) {
+	for name, test := range map[string]struct {
+		body           string
+		expectedText   string
+		expectedQuoted string
+	}{
+		"no quoted text": {
+			body:         "Hello,\n\nSee you tomorrow.",
+			expectedText: "Hello,\n\nSee you tomorrow.",
+		},
+		"quoted lines with attribution": {
+			body:           "Sounds good.\n\nOn Mon, Sep 14, 2020 at 10:30 AM Someone <someone@example.com> wrote:\n> Shall we meet?\n>\n> Thanks",
+			expectedText:   "Sounds good.",
+			expectedQuoted: "On Mon, Sep 14, 2020 at 10:30 AM Someone <someone@example.com> wrote:\n> Shall we meet?\n>\n> Thanks",
+		},
+		"attribution wrapped into two lines": {
+			body:           "Sounds good.\r\n\r\nOn Mon, Sep 14, 2020 at 10:30 AM Someone <\r\nsomeone@example.com> wrote:\r\n> Shall we meet?",
+			expectedText:   "Sounds good.",
+			expectedQuoted: "On Mon, Sep 14, 2020 at 10:30 AM Someone <\nsomeone@example.com> wrote:\n> Shall we meet?",
+		},
+		"Outlook headers": {
+			body:           "Sounds good.\n\nFrom: Someone <someone@example.com>\nSent: Monday, September 14, 2020 10:30 AM\nTo: Me <me@example.com>\nSubject: Meeting\n\nShall we meet?",
+			expectedText:   "Sounds good.",
+			expectedQuoted: "From: Someone <someone@example.com>\nSent: Monday, September 14, 2020 10:30 AM\nTo: Me <me@example.com>\nSubject: Meeting\n\nShall we meet?",
+		},
+		"Outlook headers in bold": {
+			body:           "Sounds good.\n\n**From:** Someone <someone@example.com>\n**Sent:** Monday, September 14, 2020 10:30 AM\n**Subject:** Meeting\n\nShall we meet?",
+			expectedText:   "Sounds good.",
+			expectedQuoted: "**From:** Someone <someone@example.com>\n**Sent:** Monday, September 14, 2020 10:30 AM\n**Subject:** Meeting\n\nShall we meet?",
+		},
+		"Outlook separator": {
+			body:           "Sounds good.\n\n-----Original Message-----\nFrom: Someone <someone@example.com>\nSent: Monday, September 14, 2020 10:30 AM\n\nShall we meet?",
+			expectedText:   "Sounds good.",
+			expectedQuoted: "-----Original Message-----\nFrom: Someone <someone@example.com>\nSent: Monday, September 14, 2020 10:30 AM\n\nShall we meet?",
+		},
+		"forwarded headers kept": {
+			body:         "FYI\n\n---------- Forwarded message ---------\nFrom: Someone <someone@example.com>\nDate: Mon, Sep 14, 2020 at 10:30 AM\nSubject: Meeting\nTo: Me <me@example.com>\n\nShall we meet?",
+			expectedText: "FYI\n\n---------- Forwarded message ---------\nFrom: Someone <someone@example.com>\nDate: Mon, Sep 14, 2020 at 10:30 AM\nSubject: Meeting\nTo: Me <me@example.com>\n\nShall we meet?",
+		},
+		"quoted lines followed by a reply": {
+			body:         "> Shall we meet?\n\nYes, at 10.",
+			expectedText: "> Shall we meet?\n\nYes, at 10.",
+		},
+		"only quoted text": {
+			body:         "> Shall we meet?",
+			expectedText: "> Shall we meet?",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			text, quoted := splitQuotedText(test.body)
+			assert.Equal(t, test.expectedText, text)
+			assert.Equal(t, test.expectedQuoted, quoted)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"google.golang.org/api/gmail/v1"
+)
+
+// searchResultsPerPage is the number of mails shown on each page of the search results
+const searchResultsPerPage = 5
+
+// handleSearchCommand handles the command `/gmail search <gmail query>` by listing the matching mails
+func (p *Plugin) handleSearchCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if p.checkIfConnected(args.UserId) == false {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please connect yourself to Gmail using `/gmail connect`.")
+		return &model.CommandResponse{}, nil
+	}
+
+	query := strings.TrimSpace(strings.TrimPrefix(args.Command, "/"+commandGmail+" search"))
+	if query == "" {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please provide the query to search for, eg. `/gmail search from:someone@example.com has:attachment`.")
+		return &model.CommandResponse{}, nil
+	}
+
+	searchPost, err := p.getSearchResultsPost(args.UserId, args.ChannelId, query, "")
+	if err != nil {
+		p.API.LogError("Could not search the mails of the user", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to search your mails. Please check the query and try again.")
+		return &model.CommandResponse{}, nil
+	}
+
+	p.API.SendEphemeralPost(args.UserId, searchPost)
+	return &model.CommandResponse{}, nil
+}
+
+// getSearchResultsPost creates the post listing a page of the mails matching the query,
+// with buttons to import each mail or its thread and to show the next page
+func (p *Plugin) getSearchResultsPost(userID string, channelID string, query string, pageToken string) (*model.Post, error) {
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	listResponse, err := gmailService.Users.Messages.List(gmailID).Q(query).MaxResults(searchResultsPerPage).PageToken(pageToken).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	actionURL := fmt.Sprintf("%s/plugins/%s/command/search", siteURL, manifest.Id)
+	actionSecret := p.getActionSecret()
+	location := p.getUserLocation(userID)
+
+	attachments := []*model.SlackAttachment{}
+	for _, messageInfo := range listResponse.Messages {
+		message, messageErr := gmailService.Users.Messages.Get(gmailID, messageInfo.Id).Format("metadata").MetadataHeaders("From", "Subject", "Date").Do()
+		if messageErr != nil {
+			return nil, messageErr
+		}
+
+		headers := map[string]string{}
+		if message.Payload != nil {
+			for _, header := range message.Payload.Headers {
+				headers[strings.ToLower(header.Name)] = header.Value
+			}
+		}
+
+		subject := headers["subject"]
+		if subject == "" {
+			subject = "(no subject)"
+		}
+		date := headers["date"]
+		if parsedDate, dateErr := mail.ParseDate(date); dateErr == nil {
+			date = parsedDate.In(location).Format(mailDateFormat)
+		}
+
+		attachments = append(attachments, &model.SlackAttachment{
+			AuthorName: headers["from"],
+			Title:      subject,
+			Text:       "_" + date + "_\n" + html.UnescapeString(message.Snippet),
+			Actions: []*model.PostAction{
+				getSearchAction("Import mail", actionURL, actionSecret, ActionImportMail, map[string]interface{}{"messageID": message.Id}),
+				getSearchAction("Import thread", actionURL, actionSecret, ActionImportThread, map[string]interface{}{"threadID": message.ThreadId}),
+			},
+		})
+	}
+
+	if listResponse.NextPageToken != "" {
+		attachments = append(attachments, &model.SlackAttachment{
+			Actions: []*model.PostAction{
+				getSearchAction("More", actionURL, actionSecret, ActionSearchMore, map[string]interface{}{
+					"query":     query,
+					"pageToken": listResponse.NextPageToken,
+				}),
+			},
+		})
+	}
+
+	message := "###### Mails matching `" + query + "`"
+	if len(listResponse.Messages) == 0 {
+		message = "No mails match `" + query + "`."
+		if pageToken != "" {
+			message = "No more mails match `" + query + "`."
+		}
+	}
+
+	return &model.Post{
+		UserId:    p.gmailBotID,
+		ChannelId: channelID,
+		Message:   message,
+		Props: map[string]interface{}{
+			"attachments": attachments,
+		},
+	}, nil
+}
+
+// getSearchAction creates a button of the search results post, passing the values in the context of the action
+func getSearchAction(name string, actionURL string, actionSecret string, action string, values map[string]interface{}) *model.PostAction {
+	context := map[string]interface{}{
+		"action":       action,
+		"actionSecret": actionSecret,
+	}
+	for key, value := range values {
+		context[key] = value
+	}
+
+	return &model.PostAction{
+		Type: model.POST_ACTION_TYPE_BUTTON,
+		Name: name,
+		Integration: &model.PostActionIntegration{
+			URL:     actionURL,
+			Context: context,
+		},
+	}
+}
+
+// handleSearchAction imports the mail or thread selected from the search results, or shows the next page of the results
+func (p *Plugin) handleSearchAction(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	integrationRequest := model.PostActionIntegrationRequestFromJson(r.Body)
+	if integrationRequest == nil || integrationRequest.UserId != authUserID {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	userID := integrationRequest.UserId
+	channelID := integrationRequest.ChannelId
+	actionToBeTaken, _ := integrationRequest.Context["action"].(string)
+	actionSecretPassed, _ := integrationRequest.Context["actionSecret"].(string)
+
+	if !p.isValidActionSecret(actionSecretPassed) {
+		http.Error(w, "Unauthorized search action detected", http.StatusBadRequest)
+		return
+	}
+
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		p.sendMessageFromBot(channelID, userID, true, err.Error())
+		http.Error(w, "Could not get Gmail ID of the user", http.StatusInternalServerError)
+		return
+	}
+
+	switch actionToBeTaken {
+	case ActionImportMail:
+		messageID, _ := integrationRequest.Context["messageID"].(string)
+		message, messageErr := p.getRawMessage(userID, gmailID, messageID)
+		if messageErr != nil {
+			p.API.LogError("Could not fetch the mail", "err", messageErr.Error())
+			p.sendMessageFromBot(channelID, userID, true, "Unable to get the mail.")
+			break
+		}
+		skippedPostIDs, _ := p.handleMessages([]*gmail.Message{message}, channelID, userID, false, importOptions{})
+		p.sendAlreadyImportedMessage(channelID, userID, skippedPostIDs, true)
+	case ActionImportThread:
+		threadID, _ := integrationRequest.Context["threadID"].(string)
+		threadMessages, threadErr := p.getRawThreadMessages(userID, gmailID, threadID)
+		if threadErr != nil {
+			p.API.LogError("Could not fetch the thread", "err", threadErr.Error())
+			p.sendMessageFromBot(channelID, userID, true, "Unable to get the thread.")
+			break
+		}
+		skippedPostIDs, _ := p.handleMessages(threadMessages, channelID, userID, false, importOptions{})
+		p.sendAlreadyImportedMessage(channelID, userID, skippedPostIDs, len(skippedPostIDs) == len(threadMessages))
+	case ActionSearchMore:
+		query, _ := integrationRequest.Context["query"].(string)
+		pageToken, _ := integrationRequest.Context["pageToken"].(string)
+		searchPost, searchErr := p.getSearchResultsPost(userID, channelID, query, pageToken)
+		if searchErr != nil {
+			p.API.LogError("Could not search the mails of the user", "err", searchErr.Error())
+			p.sendMessageFromBot(channelID, userID, true, "Unable to search your mails. Please try again later.")
+			break
+		}
+		searchPost.Id = integrationRequest.PostId
+		p.API.UpdateEphemeralPost(userID, searchPost)
+	default:
+		http.Error(w, "Unknown search action detected", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
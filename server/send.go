@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/mattermost/mattermost-server/v5/utils/markdown"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// dialogTextAreaMaxLength is the maximum length of a textarea element allowed in an interactive dialog
+const dialogTextAreaMaxLength = 3000
+
+// handleSendCommand handles the command `/gmail send [to]` by opening a dialog to compose the mail
+func (p *Plugin) handleSendCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if p.checkIfConnected(args.UserId) == false {
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Please connect yourself to Gmail using `/gmail connect`.")
+		return &model.CommandResponse{}, nil
+	}
+
+	to := strings.TrimSpace(strings.TrimPrefix(args.Command, "/"+commandGmail+" send"))
+
+	if err := p.openComposeDialog(args.TriggerId, &composeDialogDefaults{To: to}); err != nil {
+		p.API.LogError("Could not open dialog to compose mail", "err", err.Error())
+		p.sendMessageFromBot(args.ChannelId, args.UserId, true, "Unable to open the dialog to compose the mail. Please try again later.")
+	}
+	return &model.CommandResponse{}, nil
+}
+
+// composeDialogDefaults are the values the dialog to compose a mail is pre-filled with
+type composeDialogDefaults struct {
+	To               string
+	Subject          string
+	Body             string
+	BodyOptional     bool
+	BodyHelpText     string
+	IntroductionText string
+	// State is passed back to the send dialog endpoint on submission
+	State string
+}
+
+// openComposeDialog opens the dialog to compose a mail, the submission of which is sent to the send dialog endpoint
+func (p *Plugin) openComposeDialog(triggerID string, defaults *composeDialogDefaults) error {
+	dialog, err := p.getComposeDialog(defaults)
+	if err != nil {
+		return err
+	}
+	dialog.TriggerId = triggerID
+
+	if appErr := p.API.OpenInteractiveDialog(*dialog); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getComposeDialog returns the dialog to compose a mail, without a trigger ID
+func (p *Plugin) getComposeDialog(defaults *composeDialogDefaults) (*model.OpenDialogRequest, error) {
+	siteURL := p.API.GetConfig().ServiceSettings.SiteURL
+	if siteURL == nil {
+		return nil, fmt.Errorf("site URL is not defined in the App")
+	}
+
+	bodyHelpText := defaults.BodyHelpText
+	if bodyHelpText == "" {
+		bodyHelpText = "Markdown is supported"
+	}
+
+	return &model.OpenDialogRequest{
+		URL: fmt.Sprintf("%s/plugins/%s/dialog/send", *siteURL, manifest.Id),
+		Dialog: model.Dialog{
+			CallbackId:       "send",
+			Title:            "Send an email",
+			IntroductionText: defaults.IntroductionText,
+			SubmitLabel:      "Send",
+			State:            defaults.State,
+			Elements: []model.DialogElement{{
+				DisplayName: "To",
+				Name:        "to",
+				Type:        "text",
+				Default:     defaults.To,
+				Placeholder: "name@example.com, Name <name@example.com>",
+				HelpText:    "Comma-separated email addresses",
+			}, {
+				DisplayName: "Cc",
+				Name:        "cc",
+				Type:        "text",
+				Optional:    true,
+				HelpText:    "Comma-separated email addresses",
+			}, {
+				DisplayName: "Bcc",
+				Name:        "bcc",
+				Type:        "text",
+				Optional:    true,
+				HelpText:    "Comma-separated email addresses",
+			}, {
+				DisplayName: "Subject",
+				Name:        "subject",
+				Type:        "text",
+				Default:     defaults.Subject,
+			}, {
+				DisplayName: "Body",
+				Name:        "body",
+				Type:        "textarea",
+				Default:     defaults.Body,
+				Optional:    defaults.BodyOptional,
+				MaxLength:   dialogTextAreaMaxLength,
+				HelpText:    bodyHelpText,
+			}},
+		},
+	}, nil
+}
+
+// handleSendDialog sends the mail composed in the dialog opened by openComposeDialog
+func (p *Plugin) handleSendDialog(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.SubmitDialogRequestFromJson(r.Body)
+	if request == nil || request.UserId != authUserID {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	submission := func(name string) string {
+		value, _ := request.Submission[name].(string)
+		return strings.TrimSpace(value)
+	}
+
+	dialogErrors := map[string]string{}
+	recipients := map[string][]string{}
+	for _, field := range []string{"to", "cc", "bcc"} {
+		addresses, err := parseAddressList(submission(field))
+		if err != nil {
+			dialogErrors[field] = "Please enter valid comma-separated email addresses."
+			continue
+		}
+		recipients[field] = addresses
+	}
+	if len(dialogErrors) == 0 && len(recipients["to"])+len(recipients["cc"])+len(recipients["bcc"]) == 0 {
+		dialogErrors["to"] = "Please enter at least one recipient."
+	}
+	if len(dialogErrors) > 0 {
+		writeDialogErrors(w, dialogErrors)
+		return
+	}
+
+	gmailID, err := p.getGmailID(request.UserId)
+	if err != nil {
+		writeDialogError(w, err.Error())
+		return
+	}
+
+	body := submission("body")
+	attachments := []*mailAttachment{}
+	if request.State != "" {
+		// The dialog was opened to send a post or its thread
+		body, attachments, err = p.getSharedPostsContent(request.UserId, request.State, body)
+		if err != nil {
+			p.API.LogError("Could not fetch the posts to be sent", "err", err.Error())
+			writeDialogError(w, "Unable to send the posts: "+err.Error()+".")
+			return
+		}
+	}
+
+	rfcMessageID, err := p.sendMail(request.UserId, gmailID, &outgoingMail{
+		From:        gmailID,
+		To:          recipients["to"],
+		Cc:          recipients["cc"],
+		Bcc:         recipients["bcc"],
+		Subject:     submission("subject"),
+		TextBody:    body,
+		HTMLBody:    markdown.RenderHTML(body),
+		Attachments: attachments,
+	}, "")
+	if err != nil {
+		p.API.LogError("Could not send the mail", "err", err.Error())
+		writeDialogError(w, "Unable to send the mail. Please try again later.")
+		return
+	}
+
+	allRecipients := []string{}
+	for _, field := range []string{"to", "cc", "bcc"} {
+		allRecipients = append(allRecipients, recipients[field]...)
+	}
+	p.CreateBotDMPost(request.UserId, "Your mail with the subject **"+submission("subject")+"** was sent to "+strings.Join(allRecipients, ", ")+".\n"+
+		"**Message ID: <"+rfcMessageID+">**. _(Import in any channel using `/gmail import <mail/thread> <ID>`)_")
+	w.WriteHeader(http.StatusOK)
+}
+
+// sendMail sends the mail from the Gmail account of the user, in the given thread if not empty.
+// The RFC Message-ID of the sent message is returned, without the angle brackets.
+func (p *Plugin) sendMail(userID string, gmailID string, outgoing *outgoingMail, threadID string) (string, error) {
+	rawMessage, rfcMessageID, err := buildMIMEMessage(outgoing)
+	if err != nil {
+		return "", err
+	}
+	rfcMessageID = strings.Trim(rfcMessageID, "<>")
+
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return "", err
+	}
+
+	message := &gmail.Message{ThreadId: threadID}
+	if len(outgoing.Attachments) == 0 {
+		message.Raw = encodeRawMessage(rawMessage)
+	}
+	sendCall := gmailService.Users.Messages.Send(gmailID, message)
+	if len(outgoing.Attachments) > 0 {
+		// The size of the raw message in the request body is limited, so mails with attachments are uploaded instead
+		sendCall = sendCall.Media(bytes.NewReader(rawMessage), googleapi.ContentType("message/rfc822"))
+	}
+	sentMessage, err := sendCall.Do()
+	if err != nil {
+		return "", err
+	}
+
+	// Gmail may replace the Message-ID, so read it from the sent message
+	sentMessage, err = gmailService.Users.Messages.Get(gmailID, sentMessage.Id).Format("metadata").MetadataHeaders("Message-ID").Do()
+	if err != nil {
+		p.API.LogWarn("Could not fetch the sent message", "err", err.Error())
+		return rfcMessageID, nil
+	}
+	if sentMessage.Payload != nil {
+		for _, header := range sentMessage.Payload.Headers {
+			if strings.EqualFold(header.Name, "Message-ID") {
+				return strings.Trim(header.Value, "<>"), nil
+			}
+		}
+	}
+	return rfcMessageID, nil
+}
+
+// writeDialogErrors responds to the dialog submission with errors for the given elements
+func writeDialogErrors(w http.ResponseWriter, dialogErrors map[string]string) {
+	response := &model.SubmitDialogResponse{Errors: dialogErrors}
+	responseJSON, _ := json.Marshal(response)
+	w.Write(responseJSON)
+}
+
+// writeDialogError responds to the dialog submission with an error for the dialog
+func writeDialogError(w http.ResponseWriter, message string) {
+	response := &model.SubmitDialogResponse{Error: message}
+	responseJSON, _ := json.Marshal(response)
+	w.Write(responseJSON)
+}
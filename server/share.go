@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+// gmailMaxAttachmentsSize is the maximum total size of the files attached to a mail allowed by Gmail
+const gmailMaxAttachmentsSize = 25 * 1024 * 1024
+
+// sharedSubjectMaxLength is the maximum length of the subject derived from the shared post
+const sharedSubjectMaxLength = 78
+
+// sharedPostsState is the state of the dialog opened to send a post or its thread as an email
+type sharedPostsState struct {
+	PostID string `json:"post_id"`
+	Thread bool   `json:"thread"`
+	// AppendContent is set if the posts were too long to pre-fill the body, so they are appended to it when sending
+	AppendContent bool `json:"append_content"`
+}
+
+// handleShareDialog responds with the dialog to send a post or its thread as an email, opened by the post menu action of the webapp
+func (p *Plugin) handleShareDialog(w http.ResponseWriter, r *http.Request) {
+	// Check if this was passed within Mattermost
+	authUserID := r.Header.Get("Mattermost-User-ID")
+	if authUserID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request sharedPostsState
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PostID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	posts, err := p.getSharedPosts(authUserID, request.PostID, request.Thread)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if p.checkIfConnected(authUserID) == false {
+		p.sendMessageFromBot(posts[0].ChannelId, authUserID, true, "Please connect yourself to Gmail using `/gmail connect`.")
+		http.Error(w, "Not connected to Gmail", http.StatusBadRequest)
+		return
+	}
+
+	defaults, err := p.getShareDialogDefaults(authUserID, posts, request.PostID, request.Thread)
+	if err != nil {
+		p.API.LogError("Could not prepare the dialog to send the post as an email", "err", err.Error())
+		http.Error(w, "Unable to prepare the dialog", http.StatusInternalServerError)
+		return
+	}
+
+	dialog, err := p.getComposeDialog(defaults)
+	if err != nil {
+		p.API.LogError("Could not prepare the dialog to send the post as an email", "err", err.Error())
+		http.Error(w, "Unable to prepare the dialog", http.StatusInternalServerError)
+		return
+	}
+
+	dialogJSON, err := json.Marshal(dialog)
+	if err != nil {
+		http.Error(w, "Unable to prepare the dialog", http.StatusInternalServerError)
+		return
+	}
+	w.Write(dialogJSON)
+}
+
+// getShareDialogDefaults returns the values to pre-fill the dialog to send the posts as an email with
+func (p *Plugin) getShareDialogDefaults(userID string, posts []*model.Post, postID string, thread bool) (*composeDialogDefaults, error) {
+	content := p.formatSharedPosts(userID, posts)
+	state := sharedPostsState{
+		PostID:        postID,
+		Thread:        thread,
+		AppendContent: utf8.RuneCountInString(content) > dialogTextAreaMaxLength,
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := &composeDialogDefaults{
+		Subject: getSharedSubject(posts[0]),
+		Body:    content,
+		State:   string(stateJSON),
+	}
+
+	introduction := []string{}
+	if state.AppendContent {
+		defaults.Body = ""
+		defaults.BodyOptional = true
+		defaults.BodyHelpText = "Markdown is supported. The posts are added below your message."
+		introduction = append(introduction, "The posts are too long to be edited here, so they will be added below your message.")
+	}
+
+	fileInfos, err := p.getSharedFileInfos(posts)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileInfos) > 0 {
+		fileNames := []string{}
+		for _, fileInfo := range fileInfos {
+			fileNames = append(fileNames, "**"+fileInfo.Name+"**")
+		}
+		introduction = append(introduction, "Attachments: "+strings.Join(fileNames, ", "))
+	}
+	defaults.IntroductionText = strings.Join(introduction, "\n\n")
+
+	return defaults, nil
+}
+
+// getSharedPosts returns the post, or all the posts of its thread from the oldest, provided the user can read them
+func (p *Plugin) getSharedPosts(userID string, postID string, thread bool) ([]*model.Post, error) {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return nil, errors.New("the post could not be found")
+	}
+	if !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PERMISSION_READ_CHANNEL) {
+		return nil, errors.New("you do not have access to the post")
+	}
+	if !thread {
+		return []*model.Post{post}, nil
+	}
+
+	postList, appErr := p.API.GetPostThread(postID)
+	if appErr != nil {
+		return nil, errors.New("the thread of the post could not be found")
+	}
+	postList.SortByCreateAt()
+
+	posts := []*model.Post{}
+	threadPosts := postList.ToSlice()
+	for i := len(threadPosts) - 1; i >= 0; i-- {
+		if threadPosts[i].IsSystemMessage() || threadPosts[i].DeleteAt != 0 {
+			continue
+		}
+		posts = append(posts, threadPosts[i])
+	}
+	if len(posts) == 0 {
+		return []*model.Post{post}, nil
+	}
+	return posts, nil
+}
+
+// formatSharedPosts formats the posts as markdown, each with the name of its author and its time in the timezone of the user
+func (p *Plugin) formatSharedPosts(userID string, posts []*model.Post) string {
+	location := p.getUserLocation(userID)
+
+	formattedPosts := []string{}
+	for _, post := range posts {
+		author := post.UserId
+		if user, appErr := p.API.GetUser(post.UserId); appErr == nil {
+			author = "@" + user.Username
+			if fullName := user.GetFullName(); fullName != "" {
+				author = fullName + " (@" + user.Username + ")"
+			}
+		}
+
+		createdAt := time.Unix(0, post.CreateAt*int64(time.Millisecond)).In(location)
+		formattedPosts = append(formattedPosts, fmt.Sprintf("**%s** - %s\n\n%s", author, createdAt.Format("Mon, Jan 2, 2006 at 3:04 PM MST"), getPostContent(post)))
+	}
+	return strings.Join(formattedPosts, "\n\n---\n\n")
+}
+
+// getPostContent returns the message of the post followed by the content of its attachments, such as the mails posted by the plugin
+func getPostContent(post *model.Post) string {
+	content := []string{}
+	if message := strings.TrimSpace(post.Message); message != "" {
+		content = append(content, message)
+	}
+
+	for _, attachment := range post.Attachments() {
+		if attachment.Title != "" {
+			content = append(content, "**"+attachment.Title+"**")
+		}
+		for _, field := range attachment.Fields {
+			if field.Title == "" {
+				continue
+			}
+			content = append(content, fmt.Sprintf("**%s:** %v", field.Title, field.Value))
+		}
+		if attachment.Text != "" {
+			content = append(content, attachment.Text)
+		}
+	}
+	return strings.Join(content, "\n\n")
+}
+
+// getSharedSubject returns the subject for the mail sharing the posts, derived from the first line of the first post,
+// or from the title of its attachment when the post has no message
+func getSharedSubject(post *model.Post) string {
+	subject := strings.TrimSpace(strings.SplitN(strings.TrimSpace(post.Message), "\n", 2)[0])
+	if attachments := post.Attachments(); subject == "" && len(attachments) > 0 {
+		subject = strings.TrimSpace(attachments[0].Title)
+	}
+	if subject == "" {
+		return "Shared from Mattermost"
+	}
+	if utf8.RuneCountInString(subject) > sharedSubjectMaxLength {
+		subject = string([]rune(subject)[:sharedSubjectMaxLength-3]) + "..."
+	}
+	return subject
+}
+
+// getSharedFileInfos returns the details of the files attached to the posts
+func (p *Plugin) getSharedFileInfos(posts []*model.Post) ([]*model.FileInfo, error) {
+	fileInfos := []*model.FileInfo{}
+	for _, post := range posts {
+		for _, fileID := range post.FileIds {
+			fileInfo, appErr := p.API.GetFileInfo(fileID)
+			if appErr != nil {
+				return nil, appErr
+			}
+			fileInfos = append(fileInfos, fileInfo)
+		}
+	}
+	return fileInfos, nil
+}
+
+// getSharedPostsContent returns the body of the mail sharing the posts described by the dialog state, along with their files as attachments
+func (p *Plugin) getSharedPostsContent(userID string, stateJSON string, body string) (string, []*mailAttachment, error) {
+	var state sharedPostsState
+	if err := json.Unmarshal([]byte(stateJSON), &state); err != nil {
+		return "", nil, errors.New("invalid dialog state")
+	}
+
+	posts, err := p.getSharedPosts(userID, state.PostID, state.Thread)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if state.AppendContent {
+		body = strings.TrimSpace(body + "\n\n---\n\n" + p.formatSharedPosts(userID, posts))
+	}
+
+	fileInfos, err := p.getSharedFileInfos(posts)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "the files attached to the posts could not be found")
+	}
+
+	attachments := []*mailAttachment{}
+	var size int64
+	for _, fileInfo := range fileInfos {
+		size += fileInfo.Size
+		if size > gmailMaxAttachmentsSize {
+			return "", nil, errors.New("the files attached to the posts exceed the attachment size limit of Gmail")
+		}
+
+		data, appErr := p.API.GetFile(fileInfo.Id)
+		if appErr != nil {
+			return "", nil, errors.Wrap(appErr, "the files attached to the posts could not be read")
+		}
+		attachments = append(attachments, &mailAttachment{
+			Filename:    fileInfo.Name,
+			ContentType: fileInfo.MimeType,
+			Data:        data,
+		})
+	}
+	return body, attachments, nil
+}
@@ -5,13 +5,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"strconv"
 	"strings"
+	"time"
 	// "github.com/mattermost/mattermost-server/v5/mlog"
-	"github.com/DusanKasan/parsemail"
-	html2markdown "github.com/JohannesKaufmann/html-to-markdown"
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
@@ -95,18 +93,15 @@ func (p *Plugin) getOAuthConfig() *oauth2.Config {
 
 // getGmailService retrieves the token stored in database and then generates a gmail service
 func (p *Plugin) getGmailService(userID string) (*gmail.Service, error) {
-	var token oauth2.Token
-
-	tokenInByte, appErr := p.API.KVGet(userID + "gmailToken")
-	if appErr != nil || tokenInByte == nil {
-		p.API.LogError("Error occured while getting gmail token", "err", appErr)
-		return nil, errors.New(appErr.DetailedError)
+	token, err := p.getGmailToken(userID)
+	if err != nil {
+		p.API.LogError("Error occured while getting gmail token", "err", err.Error())
+		return nil, err
 	}
 
-	json.Unmarshal(tokenInByte, &token)
 	config := p.getOAuthConfig()
 	ctx := context.Background()
-	tokenSource := config.TokenSource(ctx, &token)
+	tokenSource := config.TokenSource(ctx, token)
 	gmailService, err := gmail.NewService(ctx, option.WithTokenSource(tokenSource))
 	if err != nil {
 		return nil, err
@@ -115,18 +110,61 @@ func (p *Plugin) getGmailService(userID string) (*gmail.Service, error) {
 	return gmailService, nil
 }
 
-// getOAuthService generates OAuth Service
-func (p *Plugin) getOAuthService(userID string) (*accessAPI.Service, error) {
-	var token oauth2.Token
+// storeGmailToken encrypts the token using the configured encryption key and stores it for the user
+func (p *Plugin) storeGmailToken(userID string, tokenJSON []byte) error {
+	encryptedToken, err := encrypt(p.getConfiguration().EncryptionKey, tokenJSON)
+	if err != nil {
+		return errors.Wrap(err, "could not encrypt gmail token")
+	}
+
+	if appErr := p.API.KVSet(userID+"gmailToken", encryptedToken); appErr != nil {
+		return appErr
+	}
+	return nil
+}
 
+// getGmailToken retrieves and decrypts the token stored for the user.
+// Tokens stored in plain text by earlier versions of the plugin are encrypted and stored back on first read.
+func (p *Plugin) getGmailToken(userID string) (*oauth2.Token, error) {
 	tokenInByte, appErr := p.API.KVGet(userID + "gmailToken")
 	if appErr != nil {
-		return nil, errors.New(appErr.DetailedError)
+		return nil, appErr
 	}
-	json.Unmarshal(tokenInByte, &token)
+	if tokenInByte == nil {
+		return nil, errors.New("Gmail token not found. Please connect using `/gmail connect`")
+	}
+
+	tokenJSON, err := decrypt(p.getConfiguration().EncryptionKey, tokenInByte)
+	if err != nil {
+		// Migrate the token if it was stored before encryption was introduced
+		if !json.Valid(tokenInByte) {
+			return nil, errors.Wrap(err, "could not decrypt gmail token. Please reconnect using `/gmail connect`")
+		}
+
+		p.API.LogInfo("Encrypting plain text gmail token for the user with user ID: " + userID)
+		tokenJSON = tokenInByte
+		if storeErr := p.storeGmailToken(userID, tokenJSON); storeErr != nil {
+			p.API.LogError("Could not store encrypted gmail token", "err", storeErr.Error())
+		}
+	}
+
+	var token oauth2.Token
+	if err = json.Unmarshal(tokenJSON, &token); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal gmail token")
+	}
+	return &token, nil
+}
+
+// getOAuthService generates OAuth Service
+func (p *Plugin) getOAuthService(userID string) (*accessAPI.Service, error) {
+	token, err := p.getGmailToken(userID)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx := context.Background()
 	config := p.getOAuthConfig()
-	tokenSource := config.TokenSource(ctx, &token)
+	tokenSource := config.TokenSource(ctx, token)
 	oauth2Service, err := accessAPI.NewService(ctx, option.WithTokenSource(tokenSource))
 	if err != nil {
 		return nil, err
@@ -201,10 +239,10 @@ func (p *Plugin) removeUserForGmail(gmailID string, userID string) error {
 // onboardUser onboards user to the plugin when connected to a Gmail account
 func (p *Plugin) onboardUser(userID string, tokenJSON []byte) error {
 
-	err := p.API.KVSet(userID+"gmailToken", tokenJSON)
-	if err != nil {
-		p.API.LogError("Error in setting gmail token", "err", err.Error())
-		return err
+	tokenErr := p.storeGmailToken(userID, tokenJSON)
+	if tokenErr != nil {
+		p.API.LogError("Error in setting gmail token", "err", tokenErr.Error())
+		return tokenErr
 	}
 
 	gmailID, gmailErr := p.getGmailID(userID)
@@ -213,7 +251,7 @@ func (p *Plugin) onboardUser(userID string, tokenJSON []byte) error {
 		return gmailErr
 	}
 
-	err = p.API.KVSet(userID+"gmailID", []byte(gmailID))
+	err := p.API.KVSet(userID+"gmailID", []byte(gmailID))
 	if err != nil {
 		p.API.LogError("Error in setting gmail ID as "+gmailID+" for the user with user ID: "+userID, "err", err.Error())
 		return err
@@ -225,6 +263,13 @@ func (p *Plugin) onboardUser(userID string, tokenJSON []byte) error {
 		return gmailErr
 	}
 
+	// The history ID left from an earlier connection may belong to another mailbox,
+	// so it is set again from the watch started while subscribing
+	if appErr := p.API.KVDelete(userID + "historyID"); appErr != nil {
+		p.API.LogError("Error in clearing the history ID of the user with user ID: "+userID, "err", appErr.Error())
+		return appErr
+	}
+
 	labelErr := p.subscribeToLabels(userID, gmailID, p.getSupportedLabels())
 	if labelErr != nil {
 		p.API.LogError("Error in subscribing user with user ID: "+userID+" to all supported labels", "err", labelErr.Error())
@@ -247,8 +292,23 @@ func (p *Plugin) offboardUser(userID string) error {
 
 	p.API.KVDelete(userID + "subscriptions")
 
+	p.API.KVDelete(userID + "channelSubscriptions")
+
+	p.API.KVDelete(userID + "followedThreads")
+
+	// Stop watching labels only the user was subscribed to
+	if _, watchErr := p.updateWatchForGmail(userID, gmailID); watchErr != nil {
+		p.API.LogError("Could not update Gmail watch while offboarding the user", "err", watchErr.Error())
+	}
+
 	p.API.KVDelete(userID + "gmailID")
 
+	p.API.KVDelete(userID + "watchExpiration")
+
+	p.API.KVDelete(userID + "watchRevoked")
+
+	p.API.KVDelete(userID + "historyID")
+
 	p.API.KVDelete(userID + "gmailToken")
 
 	p.API.LogInfo("Offboarding successfully completed for the user")
@@ -276,7 +336,67 @@ func (p *Plugin) updateSubscriptionsOfUser(userID string, labelIDs []string) *mo
 // getSubscriptionsOfUser returns subscriptions of the user
 func (p *Plugin) getSubscriptionsOfUser(userID string) ([]string, error) {
 	subscriptions, err := p.API.KVGet(userID + "subscriptions")
-	return strings.Split(string(subscriptions), ","), err
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return []string{}, nil
+	}
+	return strings.Split(string(subscriptions), ","), nil
+}
+
+// updateChannelSubscriptionsOfUser updates the labels subscribed by the user for each channel
+func (p *Plugin) updateChannelSubscriptionsOfUser(userID string, channelSubscriptions map[string][]string) error {
+	if len(channelSubscriptions) == 0 {
+		if appErr := p.API.KVDelete(userID + "channelSubscriptions"); appErr != nil {
+			return appErr
+		}
+		return nil
+	}
+
+	channelSubscriptionsJSON, err := json.Marshal(channelSubscriptions)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(userID+"channelSubscriptions", channelSubscriptionsJSON); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getChannelSubscriptionsOfUser returns the labels subscribed by the user for each channel, keyed by channel ID
+func (p *Plugin) getChannelSubscriptionsOfUser(userID string) (map[string][]string, error) {
+	channelSubscriptions := map[string][]string{}
+
+	channelSubscriptionsJSON, appErr := p.API.KVGet(userID + "channelSubscriptions")
+	if appErr != nil {
+		return nil, appErr
+	}
+	if channelSubscriptionsJSON == nil {
+		return channelSubscriptions, nil
+	}
+
+	if err := json.Unmarshal(channelSubscriptionsJSON, &channelSubscriptions); err != nil {
+		return nil, err
+	}
+	return channelSubscriptions, nil
+}
+
+// getAllSubscriptionsOfUser returns the labels subscribed by the user either for the direct message or for any channel
+func (p *Plugin) getAllSubscriptionsOfUser(userID string) ([]string, error) {
+	labelIDs, err := p.getSubscriptionsOfUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	channelSubscriptions, err := p.getChannelSubscriptionsOfUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, channelLabelIDs := range channelSubscriptions {
+		labelIDs = append(labelIDs, channelLabelIDs...)
+	}
+	return labelIDs, nil
 }
 
 // removeAllSubscriptionsOfUser
@@ -299,38 +419,70 @@ func (p *Plugin) getHistoryIDForUser(userID string) (uint64, error) {
 	return uint64(0), err
 }
 
-// getThreadID generates ID of thread from rfcID of the mail in the thread
-func (p *Plugin) getThreadID(userID string, gmailID string, rfcID string) (string, error) {
-	gmailService, err := p.getGmailService(userID)
-	if err != nil {
-		return "", err
-	}
-	listCall := gmailService.Users.Messages.List(gmailID).Q("rfc822msgid:" + rfcID)
-	listResponse, err := listCall.Do()
-	if err != nil {
-		return "", err
-	}
-	if len(listResponse.Messages) != 1 {
-		return "", errors.New("Invalid ID. Please provide ID of some mail in the thread")
+// getMessagesAddedSince walks all the history pages starting from the given history ID and returns
+// the messages added, de-duplicated by message ID, along with the latest history ID of the mailbox.
+// The messages deleted since they were added are skipped, while any other error fetching a message is returned.
+func (p *Plugin) getMessagesAddedSince(gmailService *gmail.Service, gmailID string, startHistoryID uint64) ([]*gmail.Message, uint64, error) {
+	messages := []*gmail.Message{}
+	seenMessageIDs := map[string]bool{}
+	latestHistoryID := startHistoryID
+	pageToken := ""
+
+	for {
+		listCall := gmailService.Users.History.List(gmailID).StartHistoryId(startHistoryID).HistoryTypes("messageAdded")
+		if pageToken != "" {
+			listCall = listCall.PageToken(pageToken)
+		}
+		historyResponse, err := listCall.Do()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, history := range historyResponse.History {
+			for _, addedMessage := range history.MessagesAdded {
+				messageID := addedMessage.Message.Id
+				if seenMessageIDs[messageID] {
+					continue
+				}
+				seenMessageIDs[messageID] = true
+
+				message, msgErr := gmailService.Users.Messages.Get(gmailID, messageID).Format("raw").Do()
+				if msgErr != nil {
+					if !isNotFound(msgErr) {
+						// The history ID is not advanced, so that the message is fetched again on the next notification
+						return nil, 0, errors.Wrap(msgErr, "could not fetch message with ID: "+messageID)
+					}
+					// The message was deleted since it was added
+					p.API.LogWarn("Could not fetch message with ID: "+messageID, "err", msgErr.Error())
+					continue
+				}
+				messages = append(messages, message)
+			}
+		}
+
+		if historyResponse.HistoryId > latestHistoryID {
+			latestHistoryID = historyResponse.HistoryId
+		}
+
+		pageToken = historyResponse.NextPageToken
+		if pageToken == "" {
+			break
+		}
 	}
-	return listResponse.Messages[0].ThreadId, nil
+
+	return messages, latestHistoryID, nil
 }
 
-// getMessageID generates ID of mail/message from rfcID of the mail/message
-func (p *Plugin) getMessageID(userID string, gmailID string, rfcID string) (string, error) {
-	gmailService, err := p.getGmailService(userID)
-	if err != nil {
-		return "", err
-	}
-	listCall := gmailService.Users.Messages.List(gmailID).Q("rfc822msgid:" + rfcID)
-	listResponse, err := listCall.Do()
+// getThreadID generates ID of thread from rfcID of the mail in the thread
+func (p *Plugin) getThreadID(userID string, gmailID string, rfcID string) (string, error) {
+	message, err := p.findMessageByRFCID(userID, gmailID, rfcID)
 	if err != nil {
 		return "", err
 	}
-	if len(listResponse.Messages) != 1 {
-		return "", errors.New("Invalid ID. Please provide a valid mail ID")
+	if message == nil {
+		return "", errors.New("Invalid ID. Please provide ID of some mail in the thread")
 	}
-	return listResponse.Messages[0].Id, nil
+	return message.ThreadId, nil
 }
 
 func (p *Plugin) decodeBase64URL(urlInBase64 string) (string, error) {
@@ -350,67 +502,56 @@ func (p *Plugin) decodeBase64URL(urlInBase64 string) (string, error) {
 
 	decoded, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		fmt.Println(err.Error())
 		return "", err
 	}
 
 	return string(decoded), nil
 }
 
-func (p *Plugin) parseMessage(message string) (string, string, string, string, string, []parsemail.Attachment, error) {
-	// Use parser for email
-	reader := strings.NewReader(message)
-
-	email, err := parsemail.Parse(reader) // returns Email struct and error
+// parseMessage extracts the details of the mail shown in its post from the raw message.
+// When the raw message cannot be parsed, the details are extracted from the parts of the message in Gmail instead.
+// The quoted reply history is removed from the body, unless it is to be kept.
+func (p *Plugin) parseMessage(message *gmail.Message, userID string, gmailID string, keepQuotes bool) (*parsedMessage, error) {
+	plainTextMessage, err := p.decodeBase64URL(message.Raw)
+	var parsed *parsedMessage
+	if err == nil {
+		parsed, err = parseMailMessage(plainTextMessage)
+	}
 	if err != nil {
-		// return details from self parsed message
-		p.API.LogError("Error in using parsemail package", "err", err.Error())
-		return "", "", "", "", "", nil, err
-	}
-	year, month, day := email.Date.Date()
-	date := fmt.Sprintf("%v %v, %v", month, day, year)
-
-	emailFrom := email.From
-	fromNames := ""
-	totalNames := len(emailFrom)
-	for fromIndex, fromDetails := range emailFrom {
-		if fromDetails.Name != "" {
-			fromNames += fromDetails.Name + " "
-			if fromIndex != (totalNames - 1) {
-				fromNames += ","
-			}
+		if gmailID == "" {
+			return nil, err
+		}
+		p.API.LogWarn("Could not parse the raw message, using its parts in Gmail", "messageID", message.Id, "err", err.Error())
+		if parsed, err = p.getPayloadMessage(userID, gmailID, message.Id); err != nil {
+			return nil, err
 		}
-	}
-	attachments := []parsemail.Attachment{}
-	// Attachments
-	for _, attachment := range email.Attachments {
-		attachments = append(attachments, attachment)
 	}
 
-	// Prefer HTML if available
-	if email.HTMLBody != "" {
-		mailBody, html2mdErr := html2markdown.NewConverter("", true, nil).ConvertString(email.HTMLBody)
+	// Prefer HTML if available, sanitized as it is untrusted
+	if parsed.HTMLBody != "" {
+		mailBody, quotedBody, html2mdErr := convertMailHTML(parsed.HTMLBody, p.getConfiguration().ShowLinkTargets, keepQuotes)
 		if html2mdErr == nil {
-			return email.Subject, mailBody, date, fromNames, email.MessageID, attachments, nil
+			parsed.Body = mailBody
+			parsed.QuotedBody = quotedBody
+		} else {
+			p.API.LogError("Error in converting html to markdown", "err", html2mdErr.Error())
 		}
-		p.API.LogError("Error in converting html to markdown", "err", html2mdErr.Error())
 	}
 
-	return email.Subject, email.TextBody, date, fromNames, email.MessageID, attachments, nil
-}
-
-func (p *Plugin) getAttachmentDetails(attachment parsemail.Attachment) (string, []byte) {
-	bytesData, err := ioutil.ReadAll(attachment.Data)
-	if err != nil {
-		p.API.LogError("Error occured in reading attachments", "err", err.Error())
-		return "", []byte{}
+	// The quoted reply history not marked in the HTML, or in plain text mails
+	if !keepQuotes {
+		var quotedBody string
+		parsed.Body, quotedBody = splitQuotedText(parsed.Body)
+		parsed.QuotedBody = strings.TrimSpace(parsed.QuotedBody + "\n\n" + quotedBody)
 	}
-	return attachment.Filename, bytesData
+	return parsed, nil
 }
 
-func (p *Plugin) handleMessages(messages []*gmail.Message, channelID string, userID string, notify bool) error {
+// handleMessages posts the messages in the channel as a thread, skipping the messages already imported in the channel unless forced.
+// The IDs of the posts of the skipped messages are returned.
+func (p *Plugin) handleMessages(messages []*gmail.Message, channelID string, userID string, notify bool, options importOptions) ([]string, error) {
 	if len(messages) == 0 {
-		return errors.New("No message found")
+		return nil, errors.New("No message found")
 	}
 
 	postAsID := userID
@@ -418,110 +559,347 @@ func (p *Plugin) handleMessages(messages []*gmail.Message, channelID string, use
 		postAsID = p.gmailBotID
 	}
 
-	parentID := ""
-	rootID := ""
-	for messageIndex, message := range messages {
-		base64URLMessage := message.Raw
-		plainTextMessage, err := p.decodeBase64URL(base64URLMessage)
-		if err != nil {
-			p.API.LogError("Error occured in decoding base64 URL message", "err", err.Error())
-			return err
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		p.API.LogError("Could not get gmail ID of the user, replies to the posts cannot be sent as emails", "err", err.Error())
+	}
+
+	parentID := options.RootID
+	rootID := options.RootID
+	// labels of the Gmail account, fetched once to show the labels of the messages
+	var labels []*gmail.Label
+	// usernamesByEmail caches the Mattermost users found for the addresses in the messages
+	usernamesByEmail := map[string]string{}
+	location := p.getUserLocation(userID)
+	// The blind copied recipients are only shown to the owner of the mailbox, in their direct channel with the bot
+	showBcc := false
+	if channel, appErr := p.API.GetChannel(channelID); appErr == nil {
+		showBcc = channel.Type == model.CHANNEL_DIRECT && channel.Name == model.GetDMNameFromIds(userID, p.gmailBotID)
+	}
+	// skippedPostIDs are the posts of the messages already imported in the channel
+	skippedPostIDs := []string{}
+	for _, message := range messages {
+		if !options.Force {
+			imported, importedErr := p.getImportedMessage(channelID, message.Id)
+			if importedErr != nil {
+				p.API.LogError("Could not check if the message was imported before", "err", importedErr.Error())
+			}
+			if imported != nil {
+				// Following messages are added to the thread the message was imported in
+				if rootID == "" {
+					rootID = imported.RootID
+				}
+				parentID = imported.PostIDs[len(imported.PostIDs)-1]
+				skippedPostIDs = append(skippedPostIDs, imported.PostIDs[0])
+				continue
+			}
 		}
 
-		// Extract Subject and Body (base64url) from the message.
-		subject, body, date, from, rfcID, attachments, err := p.parseMessage(plainTextMessage)
-		sharingInfo := ""
-		if notify {
-			sharingInfo = "**Message ID: <" + rfcID + ">**. _(Import in any channel using `/gmail import <mail/thread> <ID>`)_\n\n"
-		}
+		// Extract the headers, body and attachments from the message
+		parsed, err := p.parseMessage(message, userID, gmailID, options.KeepQuotes)
 		if err != nil {
 			p.API.LogError("An error has occured while trying to parse the mail", "err", err.Error())
-			return err
+			return skippedPostIDs, err
 		}
-		if from == "" {
-			from = "_Could not fetch names_"
+		sharingInfo := ""
+		if notify {
+			sharingInfo = "**Message ID: <" + parsed.MessageID + ">**. _(Import in any channel using `/gmail import <mail/thread> <ID>`)_"
 		}
 
 		fileIDArray := []string{}
 		fileNameArray := []string{}
-		for _, attachment := range attachments {
-			fileName, fileData := p.getAttachmentDetails(attachment)
-			fileInfo, fileErr := p.API.UploadFile(fileData, channelID, fileName)
-			if fileErr != nil {
-				p.API.LogError("Attachment "+fileName+" could not be uploaded", "err", err.Error())
+		// skippedAttachments are not uploaded, but can be fetched on demand
+		skippedAttachments := []*skippedAttachment{}
+		fileNameCounts := map[string]int{}
+		for _, attachment := range parsed.Attachments {
+			fileInfo, skipped := p.uploadMailFile(attachment, attachment.FileName, channelID, fileNameCounts)
+			if skipped != nil {
+				skippedAttachments = append(skippedAttachments, skipped)
+				continue
 			}
-			fileNameArray = append(fileNameArray, fileName)
+			fileNameArray = append(fileNameArray, attachment.FileName)
 			fileIDArray = append(fileIDArray, fileInfo.Id)
 		}
+		// Inline images are shown in the body of the mail and posted along with the attachments
+		embeddedFileIDs, skippedEmbeddedFiles := p.uploadEmbeddedFiles(parsed, channelID, fileNameCounts)
+		fileIDArray = append(fileIDArray, embeddedFileIDs...)
+		skippedAttachments = append(skippedAttachments, skippedEmbeddedFiles...)
 		// Prepare post for posting as a response
+		if labels == nil && gmailID != "" {
+			if labels, err = p.getLabels(userID, gmailID); err != nil {
+				p.API.LogError("Could not fetch the labels of the user", "err", err.Error())
+				labels = []*gmail.Label{}
+			}
+		}
+		usernames := p.getUsernamesByEmail(parsed.Header.addresses(showBcc), usernamesByEmail)
+		post := getMailPost(message, parsed, gmailID, getMessageLabelNames(labels, message), usernames, location, sharingInfo, showBcc)
+		post.UserId = postAsID
+		post.ChannelId = channelID
+		if notify {
+			post.AddProp(propGmailDate, parsed.DateHeader)
+			post.AddProp(propGmailInternalDate, message.InternalDate)
+		}
 
-		if messageIndex == 0 {
-			rootPost := &model.Post{
-				UserId:    postAsID,
-				ChannelId: channelID,
-				Message:   "###### Email from : " + from + "\n\n" + sharingInfo + "**Date: " + date + "** \n\n" + "**Subject: " + subject + "**\n\n" + body,
+		if parsed.QuotedBody != "" {
+			p.addQuotedTextAction(post)
+		}
+
+		// Mails too long to fit in the post are continued in replies or attached as a file
+		continuations := p.fitMailBody(post, parsed, channelID)
+
+		if rootID == "" {
+			rootPost, appErr := p.API.CreatePost(post)
+			if appErr != nil {
+				p.API.LogError("Could not create post", "err", appErr.Error())
+				return skippedPostIDs, appErr
 			}
-			rootPost, _ = p.API.CreatePost(rootPost)
 			rootID = rootPost.Id
 			parentID = rootID
 		} else {
 			// Can assume that rootID is not ""
-			post := &model.Post{
+			post.RootId = rootID
+			post.ParentId = parentID
+			postInfo, appErr := p.API.CreatePost(post)
+			if appErr != nil {
+				p.API.LogError("Could not create post", "err", appErr.Error())
+				return skippedPostIDs, appErr
+			}
+			parentID = postInfo.Id
+		}
+		importedPostIDs := []string{parentID}
+		// Record the import to avoid importing the message in the channel again, also when its following posts fail
+		recordImport := func() {
+			if importedErr := p.storeImportedMessage(channelID, message.Id, &importedMessage{RootID: rootID, PostIDs: importedPostIDs}); importedErr != nil {
+				p.API.LogError("Could not record the import of the message", "err", importedErr.Error())
+			}
+		}
+
+		if parsed.QuotedBody != "" {
+			if quoteErr := p.storeQuotedText(parentID, parsed.QuotedBody); quoteErr != nil {
+				p.API.LogError("Could not store the quoted text of the message", "err", quoteErr.Error())
+			}
+		}
+
+		// Store the details of the message to be able to reply to it from the thread
+		if gmailID != "" {
+			if replyErr := p.storeGmailPostInfoForMessage(parentID, rootID, userID, gmailID, message, parsed.RawHeader); replyErr != nil {
+				p.API.LogError("Could not store details of the message for replying", "err", replyErr.Error())
+			}
+		}
+
+		// Post the rest of the long mail
+		for _, continuation := range continuations {
+			postInfo, appErr := p.API.CreatePost(&model.Post{
 				UserId:    postAsID,
 				ChannelId: channelID,
 				RootId:    rootID,
 				ParentId:  parentID,
-				Message:   "###### Email from: " + from + "\n\n" + sharingInfo + "**Date: " + date + "** \n\n" + "**Subject: " + subject + "**\n\n" + body,
+				Message:   continuation,
+				Props:     map[string]interface{}{propFromGmailPlugin: true, propGmailMessageID: message.Id},
+			})
+			if appErr != nil {
+				p.API.LogError("Could not create post", "err", appErr.Error())
+				recordImport()
+				return skippedPostIDs, appErr
 			}
-			postInfo, _ := p.API.CreatePost(post)
 			parentID = postInfo.Id
+			importedPostIDs = append(importedPostIDs, parentID)
 		}
 
 		// Post attachments
 		if len(fileIDArray) > 0 {
 			countFiles := 0
 			// One Post can contain atmost 5 attachments
-			for countFiles = 0; countFiles <= len(fileIDArray); countFiles += 5 {
+			for countFiles = 0; countFiles < len(fileIDArray); countFiles += 5 {
 				post := &model.Post{
 					UserId:    postAsID,
 					ChannelId: channelID,
 					RootId:    rootID,
 					ParentId:  parentID,
 					FileIds:   fileIDArray[countFiles:int(math.Min(float64(countFiles+5), float64(len(fileIDArray))))],
+					Props:     map[string]interface{}{propFromGmailPlugin: true},
 				}
 				postInfo, err := p.API.CreatePost(post)
-				parentID = postInfo.Id
 				if err != nil {
 					p.API.LogError("Could not create post", "err", err.Error())
-					return err
+					recordImport()
+					return skippedPostIDs, err
 				}
+				parentID = postInfo.Id
+				importedPostIDs = append(importedPostIDs, parentID)
 			}
 		}
+
+		// List the attachments not uploaded, to be fetched on demand
+		if len(skippedAttachments) > 0 {
+			post := p.getSkippedAttachmentsPost(userID, message.Id, skippedAttachments)
+			post.UserId = postAsID
+			post.ChannelId = channelID
+			post.RootId = rootID
+			post.ParentId = parentID
+			postInfo, appErr := p.API.CreatePost(post)
+			if appErr != nil {
+				p.API.LogError("Could not create post", "err", appErr.Error())
+				recordImport()
+				return skippedPostIDs, appErr
+			}
+			parentID = postInfo.Id
+			importedPostIDs = append(importedPostIDs, parentID)
+		}
+
+		recordImport()
 	}
-	return nil
+	return skippedPostIDs, nil
 }
 
-// subscribeToLabels
+// subscribeToLabels overwrites the subscriptions of the user and updates the Gmail watch on the labels accordingly
 func (p *Plugin) subscribeToLabels(userID string, gmailID string, labelIDs []string) error {
-	gmailService, _ := p.getGmailService(userID)
-	watchRequest := &gmail.WatchRequest{
-		LabelFilterAction: "include",
-		LabelIds:          labelIDs,
-		TopicName:         p.getConfiguration().TopicName,
+	previousLabelIDs, err := p.getSubscriptionsOfUser(userID)
+	if err != nil {
+		return err
 	}
-	watchResponse, err := gmailService.Users.Watch(gmailID, watchRequest).Do()
+
+	if appErr := p.updateSubscriptionsOfUser(userID, labelIDs); appErr != nil {
+		return appErr
+	}
+
+	if err = p.refreshWatchOfUser(userID, gmailID); err != nil {
+		p.updateSubscriptionsOfUser(userID, previousLabelIDs)
+		return err
+	}
+	return nil
+}
+
+// subscribeChannelToLabels overwrites the subscriptions of the user for the channel and updates the Gmail watch on the labels accordingly.
+// The subscription of the channel is removed if no label is given.
+func (p *Plugin) subscribeChannelToLabels(userID string, gmailID string, channelID string, labelIDs []string) error {
+	channelSubscriptions, err := p.getChannelSubscriptionsOfUser(userID)
 	if err != nil {
-		p.API.LogError("Could not subscribe user to the supported labels", "err", err.Error())
 		return err
 	}
-	p.updateHistoryIDForUser(uint64(watchResponse.HistoryId), userID)
-	p.updateSubscriptionsOfUser(userID, labelIDs)
+	previousLabelIDs, subscribed := channelSubscriptions[channelID]
+
+	if len(labelIDs) > 0 {
+		channelSubscriptions[channelID] = labelIDs
+	} else {
+		delete(channelSubscriptions, channelID)
+	}
+	if err = p.updateChannelSubscriptionsOfUser(userID, channelSubscriptions); err != nil {
+		return err
+	}
+
+	if err = p.refreshWatchOfUser(userID, gmailID); err != nil {
+		if subscribed {
+			channelSubscriptions[channelID] = previousLabelIDs
+		} else {
+			delete(channelSubscriptions, channelID)
+		}
+		p.updateChannelSubscriptionsOfUser(userID, channelSubscriptions)
+		return err
+	}
+	return nil
+}
+
+// refreshWatchOfUser updates the Gmail watch after a change in the subscriptions of the user
+func (p *Plugin) refreshWatchOfUser(userID string, gmailID string) error {
+	watchResponse, err := p.updateWatchForGmail(userID, gmailID)
+	if err != nil {
+		p.API.LogError("Could not update Gmail watch for the labels", "err", err.Error())
+		return err
+	}
+
+	// Messages are fetched from the point the watch was issued. When the mailbox was already watched,
+	// the messages received since the last notification are still to be processed.
+	if watchResponse == nil {
+		p.API.KVDelete(userID + "historyID")
+	} else if historyID, historyErr := p.getHistoryIDForUser(userID); historyErr != nil || historyID == 0 {
+		p.updateHistoryIDForUser(uint64(watchResponse.HistoryId), userID)
+	}
+	return nil
+}
+
+// postNotifications posts the messages relevant to the subscriptions of the user to the direct message with the bot,
+// to each channel subscribed by the user, and to the threads followed by the user.
+// A failure to post a message does not stop posting the others. An error is then returned so that the messages
+// are fetched again with the next notification, the messages already posted in a channel being skipped.
+func (p *Plugin) postNotifications(userID string, messages []*gmail.Message) error {
+	failed := false
+	delivered, err := p.postFollowedThreadMessages(userID, messages)
+	if err != nil {
+		p.API.LogError("Could not post the messages in the followed threads", "err", err.Error())
+		failed = true
+	}
+
+	// The messages added to a followed thread already reach the user there
+	relevantMessages := []*gmail.Message{}
+	for _, message := range p.getRelevantMessagesForUser(userID, messages) {
+		if !delivered[message.Id] {
+			relevantMessages = append(relevantMessages, message)
+		}
+	}
+	if len(relevantMessages) < 1 {
+		p.API.LogInfo("No new relevant messages found for the user")
+	} else {
+		p.API.LogInfo(fmt.Sprintf("%d messages relevant based on user's subscriptions", len(relevantMessages)))
+		directChannel, channelErr := p.API.GetDirectChannel(userID, p.gmailBotID)
+		if channelErr != nil {
+			p.API.LogError("Could not fetch direct channel for the user", "err", channelErr.Error())
+			failed = true
+		} else if !p.postNotificationMessages(relevantMessages, directChannel.Id, userID) {
+			failed = true
+		}
+	}
+
+	channelSubscriptions, err := p.getChannelSubscriptionsOfUser(userID)
+	if err != nil {
+		return errors.Wrap(err, "could not fetch channel subscriptions of the user")
+	}
+	for channelID, labelIDs := range channelSubscriptions {
+		channelMessages := filterMessagesByLabels(messages, labelIDs)
+		if len(channelMessages) < 1 {
+			continue
+		}
+
+		// The user may have lost access to the channel since subscribing
+		if !p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_CREATE_POST) {
+			p.API.LogWarn("User with user ID: " + userID + " can no longer post in the subscribed channel with channel ID: " + channelID)
+			continue
+		}
+
+		p.API.LogInfo(fmt.Sprintf("%d messages relevant for the channel with channel ID: %s", len(channelMessages), channelID))
+		if !p.postNotificationMessages(channelMessages, channelID, userID) {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errors.New("some messages could not be posted")
+	}
 	return nil
 }
 
+// postNotificationMessages posts each message separately, as the messages need not belong to the same thread.
+// Messages already posted in the channel, eg. when a notification is processed again, are skipped.
+// It returns false if any of the messages could not be posted.
+func (p *Plugin) postNotificationMessages(messages []*gmail.Message, channelID string, userID string) bool {
+	posted := true
+	for _, message := range messages {
+		if _, err := p.handleMessages([]*gmail.Message{message}, channelID, userID, true, importOptions{}); err != nil {
+			p.API.LogError("Could not post the message with message ID: "+message.Id+" in the channel with channel ID: "+channelID, "err", err.Error())
+			posted = false
+		}
+	}
+	return posted
+}
+
 // getRelevantMessagesForUser filters messages that have a label the user is subscribed to
 func (p *Plugin) getRelevantMessagesForUser(userID string, messages []*gmail.Message) []*gmail.Message {
 	subscriptions, _ := p.getSubscriptionsOfUser(userID)
+	return filterMessagesByLabels(messages, subscriptions)
+}
+
+// filterMessagesByLabels filters messages that have any of the given labels
+func filterMessagesByLabels(messages []*gmail.Message, subscriptions []string) []*gmail.Message {
 	relevantMessages := []*gmail.Message{}
 	// TODO: OPTIMIZATION
 	messageAdded := false
@@ -551,3 +929,17 @@ func (p *Plugin) getSupportedLabels() []string {
 	}
 	return labels
 }
+
+// getUserLocation returns the location of the timezone preferred by the user, UTC if not known
+func (p *Plugin) getUserLocation(userID string) *time.Location {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return time.UTC
+	}
+
+	location, err := time.LoadLocation(model.GetPreferredTimezone(user.Timezone))
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
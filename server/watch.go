@@ -0,0 +1,268 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// watchRenewalInterval is the interval at which expiring Gmail watches are checked for renewal
+const watchRenewalInterval = time.Hour
+
+// watchRenewalWindow is the period before the expiry of a Gmail watch in which it is renewed
+const watchRenewalWindow = 24 * time.Hour
+
+// watchRenewalLockKey is the KV store key used to make sure a single server of the cluster renews the watches
+const watchRenewalLockKey = "watchRenewalLock"
+
+// kvListPageSize is the number of keys fetched per page when listing the KV store
+const kvListPageSize = 100
+
+// watchMailbox issues a Gmail watch for the labels and stores its expiration
+func (p *Plugin) watchMailbox(userID string, gmailID string, labelIDs []string) (*gmail.WatchResponse, error) {
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	watchRequest := &gmail.WatchRequest{
+		LabelFilterAction: "include",
+		LabelIds:          labelIDs,
+		TopicName:         p.getConfiguration().TopicName,
+	}
+	watchResponse, err := gmailService.Users.Watch(gmailID, watchRequest).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if appErr := p.updateWatchExpirationForUser(watchResponse.Expiration, userID); appErr != nil {
+		p.API.LogError("Could not store watch expiration for the user with user ID: "+userID, "err", appErr.Error())
+	}
+	return watchResponse, nil
+}
+
+// updateWatchForGmail re-issues the Gmail watch for the labels subscribed by any of the users connected to the Gmail ID,
+// using the token of the given user. The watch is stopped if no label is subscribed, in which case nil is returned.
+func (p *Plugin) updateWatchForGmail(userID string, gmailID string) (*gmail.WatchResponse, error) {
+	labelIDs, err := p.getWatchedLabelsForGmail(gmailID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(labelIDs) > 0 {
+		return p.watchMailbox(userID, gmailID, labelIDs)
+	}
+
+	gmailService, err := p.getGmailService(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = gmailService.Users.Stop(gmailID).Do(); err != nil {
+		return nil, err
+	}
+	p.API.KVDelete(userID + "watchExpiration")
+	return nil, nil
+}
+
+// getWatchedLabelsForGmail returns the union of the labels watched for the users connected to the Gmail ID
+func (p *Plugin) getWatchedLabelsForGmail(gmailID string) ([]string, error) {
+	userIDs, err := p.getUsersForGmail(gmailID)
+	if err != nil {
+		return nil, err
+	}
+
+	labelIDs := []string{}
+	addedLabelIDs := map[string]bool{}
+	for _, userID := range userIDs {
+		userLabelIDs, labelErr := p.getWatchedLabelsOfUser(userID)
+		if labelErr != nil {
+			return nil, labelErr
+		}
+		for _, labelID := range userLabelIDs {
+			if !addedLabelIDs[labelID] {
+				addedLabelIDs[labelID] = true
+				labelIDs = append(labelIDs, labelID)
+			}
+		}
+	}
+	return labelIDs, nil
+}
+
+// getWatchedLabelsOfUser returns the labels subscribed by the user for direct messages and channels,
+// along with the labels receiving the new messages of the threads followed by the user
+func (p *Plugin) getWatchedLabelsOfUser(userID string) ([]string, error) {
+	labelIDs, err := p.getAllSubscriptionsOfUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	followedThreads, err := p.getFollowedThreadsOfUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(followedThreads) > 0 {
+		labelIDs = append(labelIDs, followedThreadLabels...)
+	}
+	return labelIDs, nil
+}
+
+// updateWatchExpirationForUser stores the expiration (in milliseconds since epoch) of the Gmail watch of the user
+func (p *Plugin) updateWatchExpirationForUser(expiration int64, userID string) *model.AppError {
+	return p.API.KVSet(userID+"watchExpiration", []byte(strconv.FormatInt(expiration, 10)))
+}
+
+// getWatchExpirationForUser returns the expiration of the Gmail watch of the user, zero if not known
+func (p *Plugin) getWatchExpirationForUser(userID string) (time.Time, error) {
+	expiration, appErr := p.API.KVGet(userID + "watchExpiration")
+	if appErr != nil {
+		return time.Time{}, appErr
+	}
+	if expiration == nil {
+		return time.Time{}, nil
+	}
+
+	expirationInMillis, err := strconv.ParseInt(string(expiration), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, expirationInMillis*int64(time.Millisecond)), nil
+}
+
+// startWatchRenewalJob periodically renews the expiring Gmail watches until stopWatchRenewalJob is called
+func (p *Plugin) startWatchRenewalJob() {
+	p.watchRenewalStop = make(chan bool)
+	p.watchRenewalDone = make(chan bool)
+
+	go func() {
+		defer close(p.watchRenewalDone)
+
+		ticker := time.NewTicker(watchRenewalInterval)
+		defer ticker.Stop()
+
+		p.renewExpiringWatches()
+		for {
+			select {
+			case <-ticker.C:
+				p.renewExpiringWatches()
+			case <-p.watchRenewalStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopWatchRenewalJob stops the job started by startWatchRenewalJob and waits for it to finish
+func (p *Plugin) stopWatchRenewalJob() {
+	if p.watchRenewalStop == nil {
+		return
+	}
+	close(p.watchRenewalStop)
+	<-p.watchRenewalDone
+	p.watchRenewalStop = nil
+}
+
+// renewExpiringWatches renews the Gmail watches of connected users expiring within watchRenewalWindow.
+// The lock expires before the next run so that only one server of the cluster renews watches in a run.
+func (p *Plugin) renewExpiringWatches() {
+	lockExpiry := int64((watchRenewalInterval - time.Minute) / time.Second)
+	acquired, appErr := p.API.KVSetWithOptions(watchRenewalLockKey, []byte(model.NewId()), model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: lockExpiry,
+	})
+	if appErr != nil {
+		p.API.LogError("Could not acquire lock for renewing Gmail watches", "err", appErr.Error())
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	userIDs, err := p.getConnectedUsers()
+	if err != nil {
+		p.API.LogError("Could not list connected users for renewing Gmail watches", "err", err.Error())
+		return
+	}
+
+	renewBefore := time.Now().Add(watchRenewalWindow)
+	for _, userID := range userIDs {
+		if revoked, _ := p.API.KVGet(userID + "watchRevoked"); revoked != nil {
+			continue
+		}
+
+		if labelIDs, _ := p.getWatchedLabelsOfUser(userID); len(labelIDs) == 0 {
+			continue
+		}
+
+		expiration, expErr := p.getWatchExpirationForUser(userID)
+		if expErr != nil {
+			p.API.LogError("Could not fetch watch expiration for the user with user ID: "+userID, "err", expErr.Error())
+			continue
+		}
+		if expiration.After(renewBefore) {
+			continue
+		}
+
+		p.API.LogInfo("Renewing Gmail watch for the user with user ID: " + userID)
+		if renewErr := p.renewWatch(userID); renewErr != nil {
+			p.API.LogError("Could not renew Gmail watch for the user with user ID: "+userID, "err", renewErr.Error())
+			continue
+		}
+	}
+}
+
+// renewWatch re-issues the Gmail watch of the user
+func (p *Plugin) renewWatch(userID string) error {
+	gmailID, err := p.getGmailID(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.updateWatchForGmail(userID, gmailID)
+	if err != nil && isTokenRevoked(err) {
+		// Stop renewing the watch until the user connects again, and let the user know about it
+		p.API.KVSet(userID+"watchRevoked", []byte("true"))
+		p.CreateBotDMPost(userID, "Gmail notifications have stopped as the access to your Gmail account has been revoked or has expired. Please use `/gmail disconnect` and then `/gmail connect` to continue receiving notifications.")
+	}
+	return err
+}
+
+// getConnectedUsers returns the IDs of all the users connected to Gmail
+func (p *Plugin) getConnectedUsers() ([]string, error) {
+	userIDs := []string{}
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range keys {
+			if strings.HasSuffix(key, "gmailToken") {
+				userIDs = append(userIDs, strings.TrimSuffix(key, "gmailToken"))
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return userIDs, nil
+		}
+	}
+}
+
+// isTokenRevoked checks if the error occurred because the refresh token of the user is no longer valid
+func isTokenRevoked(err error) bool {
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+	retrieveErr, ok := cause.(*oauth2.RetrieveError)
+	if !ok {
+		return false
+	}
+	return strings.Contains(string(retrieveErr.Body), "invalid_grant")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	Rsa "github.com/dvsekhvalnov/jose2go/keys/rsa"
+	"github.com/pkg/errors"
+)
+
+// googleCertsURL lists the certificates used by Google to sign OIDC tokens, in PEM format keyed by key ID
+const googleCertsURL = "https://www.googleapis.com/oauth2/v1/certs"
+
+// googleKeysRefreshInterval is the interval after which the cached Google certificates are fetched again
+const googleKeysRefreshInterval = time.Hour
+
+// googleKeysMinRefreshInterval rate limits fetching certificates when a token signed with an unknown key is received
+const googleKeysMinRefreshInterval = time.Minute
+
+// googleIssuers are the valid issuers of the OIDC token attached by Pub/Sub
+var googleIssuers = map[string]bool{
+	"accounts.google.com":         true,
+	"https://accounts.google.com": true,
+}
+
+// pubSubClaims are the claims of the OIDC token attached by Pub/Sub to push requests
+type pubSubClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	ExpiresAt     int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+}
+
+// authenticateWebhook checks that the request to the webhook was pushed by the configured Pub/Sub subscription
+func (p *Plugin) authenticateWebhook(r *http.Request) error {
+	config := p.getConfiguration()
+
+	switch config.getWebhookAuthenticationType() {
+	case webhookAuthTypeToken:
+		token := r.URL.Query().Get("token")
+		if token == "" || config.WebhookSecret == "" {
+			return errors.New("secret token not provided")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.WebhookSecret)) != 1 {
+			return errors.New("secret token does not match")
+		}
+		return nil
+
+	case webhookAuthTypeJWT:
+		authHeader := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			return errors.New("bearer token not provided")
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+
+		audience := config.PubSubAudience
+		if audience == "" {
+			audience = p.getWebhookURL()
+		}
+
+		return verifyPubSubJWT(token, p.getGoogleSigningKey, audience, config.PubSubServiceAccountEmail, time.Now())
+	}
+
+	return errors.New("webhook authentication is not configured")
+}
+
+// verifyPubSubJWT verifies the signature and claims of the OIDC token attached by Pub/Sub.
+// getKey returns the public key for the given key ID.
+func verifyPubSubJWT(token string, getKey func(keyID string) (*rsa.PublicKey, error), audience string, serviceAccountEmail string, now time.Time) error {
+	payload, _, err := jose.Decode(token, func(headers map[string]interface{}, payload string) interface{} {
+		if alg, _ := headers["alg"].(string); alg != jose.RS256 {
+			return errors.Errorf("unexpected signing algorithm %q", alg)
+		}
+
+		keyID, _ := headers["kid"].(string)
+		key, keyErr := getKey(keyID)
+		if keyErr != nil {
+			return keyErr
+		}
+		return key
+	})
+	if err != nil {
+		return errors.Wrap(err, "invalid token")
+	}
+
+	var claims pubSubClaims
+	if err = json.Unmarshal([]byte(payload), &claims); err != nil {
+		return errors.Wrap(err, "invalid token claims")
+	}
+
+	if !googleIssuers[claims.Issuer] {
+		return errors.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if claims.Audience != audience {
+		return errors.Errorf("unexpected audience %q", claims.Audience)
+	}
+	if claims.Email != serviceAccountEmail || !claims.EmailVerified {
+		return errors.Errorf("unexpected service account %q", claims.Email)
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return errors.New("token has expired")
+	}
+	if claims.IssuedAt > now.Add(time.Minute).Unix() {
+		return errors.New("token is issued in the future")
+	}
+
+	return nil
+}
+
+// getGoogleSigningKey returns the public key of Google with the given key ID, fetching the certificates if required
+func (p *Plugin) getGoogleSigningKey(keyID string) (*rsa.PublicKey, error) {
+	p.googleKeysLock.Lock()
+	defer p.googleKeysLock.Unlock()
+
+	sinceFetched := time.Since(p.googleKeysFetchedAt)
+	key, found := p.googleKeys[keyID]
+	if found && sinceFetched < googleKeysRefreshInterval {
+		return key, nil
+	}
+
+	if found || sinceFetched >= googleKeysMinRefreshInterval {
+		keys, err := fetchGoogleSigningKeys()
+		if err != nil {
+			p.API.LogError("Could not fetch Google certificates", "err", err.Error())
+			if found {
+				return key, nil
+			}
+			return nil, err
+		}
+		p.googleKeys = keys
+		p.googleKeysFetchedAt = time.Now()
+	}
+
+	if key, found = p.googleKeys[keyID]; !found {
+		return nil, errors.Errorf("unknown key ID %q", keyID)
+	}
+	return key, nil
+}
+
+// fetchGoogleSigningKeys fetches the certificates used by Google to sign OIDC tokens
+func fetchGoogleSigningKeys() (map[string]*rsa.PublicKey, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(googleCertsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %d while fetching certificates", response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates map[string]string
+	if err = json.Unmarshal(body, &certificates); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for keyID, certificate := range certificates {
+		key, keyErr := Rsa.ReadPublic([]byte(certificate))
+		if keyErr != nil {
+			return nil, errors.Wrap(keyErr, "could not read certificate with key ID "+keyID)
+		}
+		keys[keyID] = key
+	}
+	return keys, nil
+}
+
+// getWebhookURL returns the URL of the endpoint receiving notifications from Pub/Sub
+func (p *Plugin) getWebhookURL() string {
+	return fmt.Sprintf("%s/plugins/%s/webhook/gmail", *p.API.GetConfig().ServiceSettings.SiteURL, manifest.Id)
+}
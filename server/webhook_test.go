@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/dvsekhvalnov/jose2go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAudience            = "https://mattermost.example.com/plugins/mattermost-plugin-gmail/webhook/gmail"
+	testServiceAccountEmail = "pubsub-push@project.iam.gserviceaccount.com"
+	testKeyID               = "test-key"
+)
+
+func TestVerifyPubSubJWT(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	now := time.Unix(1600000000, 0)
+	getKey := func(keyID string) (*rsa.PublicKey, error) {
+		if keyID != testKeyID {
+			return nil, errors.Errorf("unknown key ID %q", keyID)
+		}
+		return &privateKey.PublicKey, nil
+	}
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            "https://accounts.google.com",
+			"aud":            testAudience,
+			"email":          testServiceAccountEmail,
+			"email_verified": true,
+			"iat":            now.Add(-time.Minute).Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+		}
+	}
+	sign := func(t *testing.T, claims map[string]interface{}, algorithm string, key interface{}, keyID string) string {
+		payload, err := json.Marshal(claims)
+		require.NoError(t, err)
+		token, err := jose.Sign(string(payload), algorithm, key, jose.Header("kid", keyID))
+		require.NoError(t, err)
+		return token
+	}
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[name] = value
+		return claims
+	}
+
+	for name, test := range map[string]struct {
+		token       func(t *testing.T) string
+		expectedErr string
+	}{
+		"valid token": {
+			token: func(t *testing.T) string {
+				return sign(t, validClaims(), jose.RS256, privateKey, testKeyID)
+			},
+		},
+		"issuer without scheme": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("iss", "accounts.google.com"), jose.RS256, privateKey, testKeyID)
+			},
+		},
+		"wrong issuer": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("iss", "https://evil.example.com"), jose.RS256, privateKey, testKeyID)
+			},
+			expectedErr: "unexpected issuer",
+		},
+		"wrong audience": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("aud", "https://evil.example.com/webhook"), jose.RS256, privateKey, testKeyID)
+			},
+			expectedErr: "unexpected audience",
+		},
+		"wrong email": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("email", "someone@example.com"), jose.RS256, privateKey, testKeyID)
+			},
+			expectedErr: "unexpected service account",
+		},
+		"email not verified": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("email_verified", false), jose.RS256, privateKey, testKeyID)
+			},
+			expectedErr: "unexpected service account",
+		},
+		"expired token": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("exp", now.Add(-time.Second).Unix()), jose.RS256, privateKey, testKeyID)
+			},
+			expectedErr: "token has expired",
+		},
+		"token issued in the future": {
+			token: func(t *testing.T) string {
+				return sign(t, withClaim("iat", now.Add(time.Hour).Unix()), jose.RS256, privateKey, testKeyID)
+			},
+			expectedErr: "token is issued in the future",
+		},
+		"HS256 token": {
+			token: func(t *testing.T) string {
+				return sign(t, validClaims(), jose.HS256, []byte("shared secret"), testKeyID)
+			},
+			expectedErr: "unexpected signing algorithm",
+		},
+		"unsigned token": {
+			token: func(t *testing.T) string {
+				return sign(t, validClaims(), jose.NONE, nil, testKeyID)
+			},
+			expectedErr: "unexpected signing algorithm",
+		},
+		"unknown key ID": {
+			token: func(t *testing.T) string {
+				return sign(t, validClaims(), jose.RS256, privateKey, "other-key")
+			},
+			expectedErr: "unknown key ID",
+		},
+		"signed with another key": {
+			token: func(t *testing.T) string {
+				return sign(t, validClaims(), jose.RS256, otherKey, testKeyID)
+			},
+			expectedErr: "invalid token",
+		},
+		"tampered signature": {
+			token: func(t *testing.T) string {
+				token := sign(t, validClaims(), jose.RS256, privateKey, testKeyID)
+				parts := strings.Split(token, ".")
+				signature := []byte(parts[2])
+				if signature[0] == 'A' {
+					signature[0] = 'B'
+				} else {
+					signature[0] = 'A'
+				}
+				parts[2] = string(signature)
+				return strings.Join(parts, ".")
+			},
+			expectedErr: "invalid token",
+		},
+		"tampered claims": {
+			token: func(t *testing.T) string {
+				token := sign(t, validClaims(), jose.RS256, privateKey, testKeyID)
+				forged := strings.Split(sign(t, withClaim("email", "someone@example.com"), jose.RS256, otherKey, testKeyID), ".")
+				parts := strings.Split(token, ".")
+				parts[1] = forged[1]
+				return strings.Join(parts, ".")
+			},
+			expectedErr: "invalid token",
+		},
+		"malformed token": {
+			token: func(t *testing.T) string {
+				return "not a token"
+			},
+			expectedErr: "invalid token",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := verifyPubSubJWT(test.token(t), getKey, testAudience, testServiceAccountEmail, now)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+		})
+	}
+}
+
+func TestConfigurationIsValidWebhookAuthentication(t *testing.T) {
+	baseConfiguration := configuration{
+		GmailOAuthClientID: "client-id",
+		GmailOAuthSecret:   "client-secret",
+		TopicName:          "projects/project/topics/gmail",
+		EncryptionKey:      "encryption-key",
+	}
+
+	for name, test := range map[string]struct {
+		update       func(c *configuration)
+		expectedType string
+		valid        bool
+	}{
+		"JWT with service account": {
+			update: func(c *configuration) {
+				c.WebhookAuthenticationType = webhookAuthTypeJWT
+				c.PubSubServiceAccountEmail = testServiceAccountEmail
+			},
+			expectedType: webhookAuthTypeJWT,
+			valid:        true,
+		},
+		"JWT without service account": {
+			update: func(c *configuration) {
+				c.WebhookAuthenticationType = webhookAuthTypeJWT
+			},
+			expectedType: webhookAuthTypeJWT,
+		},
+		"token with secret": {
+			update: func(c *configuration) {
+				c.WebhookAuthenticationType = webhookAuthTypeToken
+				c.WebhookSecret = "secret"
+			},
+			expectedType: webhookAuthTypeToken,
+			valid:        true,
+		},
+		"token without secret": {
+			update: func(c *configuration) {
+				c.WebhookAuthenticationType = webhookAuthTypeToken
+			},
+			expectedType: webhookAuthTypeToken,
+		},
+		"not selected after upgrade": {
+			update:       func(c *configuration) {},
+			expectedType: webhookAuthTypeToken,
+			valid:        true,
+		},
+		"unknown type": {
+			update: func(c *configuration) {
+				c.WebhookAuthenticationType = "basic"
+			},
+			expectedType: "basic",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := baseConfiguration.Clone()
+			test.update(config)
+			assert.Equal(t, test.expectedType, config.getWebhookAuthenticationType())
+			if test.valid {
+				assert.NoError(t, config.IsValid())
+			} else {
+				assert.Error(t, config.IsValid())
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+import {id as pluginId} from './manifest';
+
+// RECEIVED_DIALOG is the action type the webapp handles to open an interactive dialog
+const RECEIVED_DIALOG = 'RECEIVED_DIALOG';
+
+class Plugin {
+    initialize(registry, store) {
+        registry.registerPostDropdownMenuAction(
+            'Send as email',
+            (postId) => openShareDialog(store, postId, false),
+        );
+        registry.registerPostDropdownMenuAction(
+            'Send thread as email',
+            (postId) => openShareDialog(store, postId, true),
+            (postId) => isInThread(store.getState(), postId),
+        );
+    }
+}
+
+// isInThread checks if the post is a reply or has replies
+function isInThread(state, postId) {
+    const post = state.entities.posts.posts[postId];
+    return Boolean(post && (post.root_id || post.reply_count > 0));
+}
+
+// openShareDialog fetches the dialog to send the post or its thread as an email from the server and opens it
+async function openShareDialog(store, postId, thread) {
+    const siteURL = store.getState().entities.general.config.SiteURL || window.location.origin;
+    const response = await fetch(`${siteURL}/plugins/${pluginId}/dialog/share`, {
+        method: 'POST',
+        credentials: 'same-origin',
+        headers: {
+            'Content-Type': 'application/json',
+            'X-Requested-With': 'XMLHttpRequest',
+            'X-CSRF-Token': getCSRFToken(),
+        },
+        body: JSON.stringify({post_id: postId, thread}),
+    });
+    if (!response.ok) {
+        console.error('Unable to open the dialog to send the post as an email: ' + await response.text()); // eslint-disable-line no-console
+        return;
+    }
+
+    const dialog = await response.json();
+    store.dispatch({type: RECEIVED_DIALOG, data: dialog});
+}
+
+// getCSRFToken returns the CSRF token of the session, set as a cookie by the server
+function getCSRFToken() {
+    const cookie = document.cookie.split(';').map((c) => c.trim()).find((c) => c.startsWith('MMCSRF='));
+    return cookie ? cookie.substring('MMCSRF='.length) : '';
+}
+
+window.registerPlugin(pluginId, new Plugin());
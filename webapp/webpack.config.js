@@ -0,0 +1,10 @@
+const path = require('path');
+
+module.exports = {
+    entry: './src/index.js',
+    output: {
+        path: path.join(__dirname, 'dist'),
+        filename: 'main.js',
+    },
+    devtool: false,
+};